@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+const (
+	BalancingRoundRobin = "round_robin"
+	BalancingRandom     = "random"
+	BalancingLeastConns = "least_conns"
+)
+
+// balancer picks one of the available replicas. The slice is never empty.
+type balancer interface {
+	pick(replicas []*replica) *replica
+}
+
+func getBalancer(strategy string) balancer {
+	switch strategy {
+	case BalancingRandom:
+		return randomBalancer{}
+
+	case BalancingLeastConns:
+		return leastConnsBalancer{}
+
+	default:
+		return &roundRobinBalancer{}
+	}
+}
+
+type roundRobinBalancer struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobinBalancer) pick(replicas []*replica) *replica {
+	n := b.next.Add(1) - 1
+	return replicas[n%uint64(len(replicas))]
+}
+
+type randomBalancer struct{}
+
+func (randomBalancer) pick(replicas []*replica) *replica {
+	return replicas[rand.IntN(len(replicas))]
+}
+
+// leastConnsBalancer picks the replica with the fewest acquired connections according to pgxpool.Stat.
+type leastConnsBalancer struct{}
+
+func (leastConnsBalancer) pick(replicas []*replica) *replica {
+	best, bestConns := replicas[0], replicas[0].pool.Stat().AcquiredConns()
+	for _, r := range replicas[1:] {
+		if conns := r.pool.Stat().AcquiredConns(); conns < bestConns {
+			best, bestConns = r, conns
+		}
+	}
+	return best
+}
@@ -0,0 +1,356 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/tracelog"
+	"github.com/mkbeh/xpg/internal/pkg/pgxmetrics"
+	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cluster owns one writer pool and one reader pool per replica, all built from the same Config.
+// Query, QueryRow and Exec route read-only statements to replicas and everything else to the writer.
+// Replicas failing a health check are ejected from routing and re-admitted once they respond again,
+// replicas lagging behind the writer more than Config.MaxReplicaLag are skipped until they catch up.
+type Cluster struct {
+	writer   *Pool
+	replicas []*replica
+	balancer balancer
+	maxLag   time.Duration
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+type replica struct {
+	pool     *Pool
+	endpoint string
+	// ejectedUntil holds the unix nano time of the next health check of an ejected replica, 0 if it is healthy.
+	ejectedUntil atomic.Int64
+	// lag holds the last measured replication lag in nanoseconds.
+	lag atomic.Int64
+	// measured is set once the lag has been measured.
+	measured atomic.Bool
+	// replayed holds the last known WAL position replayed by the replica.
+	replayed atomic.Uint64
+}
+
+func newReplica(pool *Pool, endpoint string) (*replica, error) {
+	r := &replica{pool: pool, endpoint: endpoint}
+
+	err := pool.registerCollector(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   pool.namespace,
+		Subsystem:   "postgres",
+		Name:        "replica_lag_seconds",
+		Help:        "Last measured replication lag of the replica.",
+		ConstLabels: pool.labels,
+	}, func() float64 {
+		return time.Duration(r.lag.Load()).Seconds()
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// available reports whether the replica is healthy and not lagging behind more than maxLag.
+// With maxLag set, a replica whose lag has not been measured yet is not available.
+func (r *replica) available(maxLag time.Duration) bool {
+	if r.ejectedUntil.Load() != 0 {
+		return false
+	}
+	return maxLag <= 0 || (r.measured.Load() && time.Duration(r.lag.Load()) <= maxLag)
+}
+
+// replicationLagQuery returns 0 on an idle replica streaming from the writer that has replayed
+// everything it received, otherwise the age of the last replayed transaction.
+// A replica without an active WAL receiver is not known to be idle, so its lag is never reported as 0.
+const replicationLagQuery = `
+	SELECT CASE
+	   WHEN NOT pg_is_in_recovery() THEN 0
+	   WHEN NOT EXISTS (SELECT FROM pg_stat_wal_receiver)
+	       THEN COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())::float8, 'Infinity')
+	   WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	   ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())::float8, 0)
+	   END AS lag;`
+
+// maxLagSeconds caps the measured lag, so it fits into a time.Duration.
+const maxLagSeconds = float64(math.MaxInt64 / int64(time.Second))
+
+func (r *replica) measureLag(ctx context.Context) error {
+	var lag float64
+	if err := r.pool.Pool.QueryRow(ctx, replicationLagQuery).Scan(&lag); err != nil {
+		return err
+	}
+	r.lag.Store(int64(min(lag, maxLagSeconds) * float64(time.Second)))
+	r.measured.Store(true)
+	return nil
+}
+
+func NewCluster(opts ...Option) (*Cluster, error) {
+	return NewClusterContext(context.Background(), opts...)
+}
+
+// NewClusterContext is like NewCluster but gives up connecting and migrating once ctx is done.
+func NewClusterContext(ctx context.Context, opts ...Option) (*Cluster, error) {
+	writer, err := newPool(ctx, true, append(opts[:len(opts):len(opts)], withCluster()))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		writer:   writer,
+		balancer: getBalancer(writer.cfg.ReplicaBalancing),
+		maxLag:   writer.cfg.MaxReplicaLag,
+	}
+
+	endpoints := writer.cfg.replicaEndpoints()
+	for i, endpoint := range endpoints {
+		reader, err := newPool(ctx, false, append(opts[:len(opts):len(opts)], withCluster(), withEndpoint(endpoint), withReaders(len(endpoints)-i)))
+		if err != nil {
+			return nil, errors.Join(err, c.Close())
+		}
+		r, err := newReplica(reader, endpoint)
+		if err != nil {
+			return nil, errors.Join(err, reader.Close(), c.Close())
+		}
+		c.replicas = append(c.replicas, r)
+	}
+
+	checkCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.wg.Go(func() {
+		c.checkReplicasLoop(checkCtx, writer.cfg.getReplicaCheckInterval(), writer.cfg.getReplicaCooldown())
+	})
+
+	return c, nil
+}
+
+// Writer returns the master pool.
+func (c *Cluster) Writer() *Pool {
+	return c.writer
+}
+
+// Reader returns a healthy and fresh enough replica pool chosen by the configured balancing strategy,
+// or the writer if no replica is available.
+func (c *Cluster) Reader() *Pool {
+	available := c.availableReplicas()
+	if len(available) == 0 {
+		return c.writer
+	}
+	return c.balancer.pick(available).pool
+}
+
+func (c *Cluster) availableReplicas() []*replica {
+	available := make([]*replica, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		if r.available(c.maxLag) {
+			available = append(available, r)
+		}
+	}
+	return available
+}
+
+// Readers returns all replica pools of the cluster, including ejected ones.
+func (c *Cluster) Readers() []*Pool {
+	readers := make([]*Pool, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		readers = append(readers, r.pool)
+	}
+	return readers
+}
+
+// SetLogLevel changes the pgx log level of the writer and all replicas.
+func (c *Cluster) SetLogLevel(level tracelog.LogLevel) {
+	c.writer.SetLogLevel(level)
+	for _, r := range c.replicas {
+		r.pool.SetLogLevel(level)
+	}
+}
+
+// Ready waits until the writer and all replicas have started, see Pool.Ready.
+func (c *Cluster) Ready(ctx context.Context) error {
+	errs := []error{c.writer.Ready(ctx)}
+	for _, r := range c.replicas {
+		errs = append(errs, r.pool.Ready(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Cluster) QueryBuilder() squirrel.StatementBuilderType {
+	return c.writer.QueryBuilder()
+}
+
+func (c *Cluster) Close() error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+
+	for _, r := range c.replicas {
+		_ = r.pool.Close()
+	}
+	return c.writer.Close()
+}
+
+func (c *Cluster) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	return c.route(ctx, sql).Exec(ctx, sql, arguments...)
+}
+
+func (c *Cluster) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return c.route(ctx, sql).Query(ctx, sql, args...)
+}
+
+func (c *Cluster) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return c.route(ctx, sql).QueryRow(ctx, sql, args...)
+}
+
+func (c *Cluster) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return c.writer.SendBatch(ctx, b)
+}
+
+// RunInTxx alias for RunInTx.
+func (c *Cluster) RunInTxx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return c.writer.RunInTxx(ctx, fn)
+}
+
+func (c *Cluster) RunInTxWithRetry(ctx context.Context, fn func(ctx context.Context) error, txOptions pgx.TxOptions, opts ...TxOption) error {
+	return c.writer.RunInTxWithRetry(ctx, fn, txOptions, opts...)
+}
+
+// RunInReadTx runs fn in a read-only transaction on a replica, or on the writer if ctx already carries
+// a transaction or forces the writer. Deferrable transactions always run on the writer, since a hot standby
+// does not support SERIALIZABLE. See Pool.RunInReadTx.
+func (c *Cluster) RunInReadTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if newTxOptions(opts).deferrable {
+		return c.writer.RunInReadTx(ctx, fn, opts...)
+	}
+	return c.readPool(ctx).RunInReadTx(ctx, fn, opts...)
+}
+
+func (c *Cluster) RunInTx(ctx context.Context, fn func(ctx context.Context) error, txOptions pgx.TxOptions, opts ...TxOption) error {
+	return c.writer.RunInTx(ctx, fn, txOptions, opts...)
+}
+
+// route picks the writer for transactions, forced writes and statements that may modify data,
+// otherwise one of the replicas, honoring read-your-writes consistency if ctx tracks it.
+func (c *Cluster) route(ctx context.Context, sql string) *Pool {
+	if !isReadOnlySQL(sql) {
+		return c.writer
+	}
+	return c.readPool(ctx)
+}
+
+// readPool picks the pool for reads, see route.
+func (c *Cluster) readPool(ctx context.Context) *Pool {
+	if extractTx(ctx) != nil || writerForced(ctx) {
+		return c.writer
+	}
+	if t := extractLSNTracker(ctx); t != nil {
+		return c.consistentReader(ctx, t)
+	}
+	return c.Reader()
+}
+
+func (c *Cluster) checkReplicasLoop(ctx context.Context, interval, cooldown time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	c.checkReplicas(ctx, interval, cooldown)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkReplicas(ctx, interval, cooldown)
+		}
+	}
+}
+
+// checkReplicas measures the lag of every healthy replica and every ejected one whose cool-down has passed.
+// The lag query doubles as a ping: a replica failing it is ejected.
+func (c *Cluster) checkReplicas(ctx context.Context, timeout, cooldown time.Duration) {
+	var wg sync.WaitGroup
+	for _, r := range c.replicas {
+		if until := r.ejectedUntil.Load(); until != 0 && time.Now().UnixNano() < until {
+			continue
+		}
+
+		wg.Go(func() {
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			if err := r.measureLag(checkCtx); err != nil {
+				if r.ejectedUntil.Swap(time.Now().Add(cooldown).UnixNano()) == 0 {
+					r.pool.Logger().WarnContext(ctx, "replica ejected",
+						slog.String("endpoint", r.endpoint),
+						pgxslog.Error(err))
+				}
+				return
+			}
+
+			if r.ejectedUntil.Swap(0) != 0 {
+				r.pool.Logger().InfoContext(ctx, "replica re-admitted",
+					slog.String("endpoint", r.endpoint))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+type writerKey struct{}
+
+var writerMarkerKey = &writerKey{}
+
+// UseWriter makes Cluster route every statement executed with the returned context to the writer.
+func UseWriter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writerMarkerKey, true)
+}
+
+func writerForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(writerMarkerKey).(bool)
+	return forced
+}
+
+var (
+	readOnlyStatements = map[string]bool{"SELECT": true, "WITH": true, "SHOW": true, "TABLE": true, "VALUES": true}
+	// INTO catches SELECT ... INTO new_table, which creates a table.
+	writeKeywords = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "INTO": true, "NEXTVAL": true, "SETVAL": true}
+)
+
+// isReadOnlySQL reports whether the statement is safe to execute on a replica.
+// Statements containing a write keyword anywhere, even in a string literal, go to the writer.
+// Functions are not inspected, so a SELECT calling a function that writes, e.g. SELECT my_writing_fn(),
+// still goes to a replica and has to be routed with UseWriter.
+func isReadOnlySQL(sql string) bool {
+	words := strings.FieldsFunc(strings.ToUpper(pgxmetrics.StripLeadingComments(sql)), func(r rune) bool {
+		return r != '_' && (r < 'A' || r > 'Z') && (r < '0' || r > '9')
+	})
+	if len(words) == 0 || !readOnlyStatements[words[0]] {
+		return false
+	}
+
+	for i, w := range words {
+		if writeKeywords[w] {
+			return false
+		}
+		// row locks: FOR UPDATE is caught above, FOR SHARE and FOR KEY SHARE here.
+		if w == "FOR" && i+1 < len(words) && (words[i+1] == "SHARE" || words[i+1] == "KEY") {
+			return false
+		}
+	}
+
+	return true
+}
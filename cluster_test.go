@@ -0,0 +1,44 @@
+package postgres
+
+import "testing"
+
+func TestIsReadOnlySQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"SELECT 1", true},
+		{"select * from users where id = $1", true},
+		{"SHOW search_path", true},
+		{"TABLE users", true},
+		{"VALUES (1), (2)", true},
+		{"WITH u AS (SELECT * FROM users) SELECT * FROM u", true},
+		{"-- name: GetUser :one\nSELECT * FROM users", true},
+		{"/* comment */ SELECT 1", true},
+		{"SELECT updated_at, into_col, deleted FROM users", true},
+		{"", false},
+		{"-- only a comment", false},
+		{"INSERT INTO users (name) VALUES ($1)", false},
+		{"/* SELECT */ UPDATE users SET name = $1", false},
+		{"-- SELECT\nDELETE FROM users", false},
+		{"WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", false},
+		{"WITH i AS (INSERT INTO users (name) VALUES ($1) RETURNING id) SELECT id FROM i", false},
+		{"SELECT * INTO new_users FROM users", false},
+		{"SELECT * FROM users FOR UPDATE", false},
+		{"SELECT * FROM users FOR NO KEY UPDATE", false},
+		{"SELECT * FROM users FOR SHARE", false},
+		{"SELECT * FROM users FOR KEY SHARE", false},
+		{"SELECT nextval('users_id_seq')", false},
+		{"SELECT setval('users_id_seq', 10)", false},
+		{"SET search_path TO app", false},
+		{"CREATE TABLE t AS SELECT 1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			if got := isReadOnlySQL(tt.sql); got != tt.want {
+				t.Errorf("isReadOnlySQL(%q) = %v, want %v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
+)
+
+// LSN is a position in the PostgreSQL write-ahead log.
+type LSN uint64
+
+// ParseLSN parses the textual pg_lsn representation, e.g. "16/B374D848".
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid lsn %q", s)
+	}
+
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %q: %w", s, err)
+	}
+
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %q: %w", s, err)
+	}
+
+	return LSN(h<<32 | l), nil
+}
+
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint64(l)>>32, uint32(l))
+}
+
+// lsnTracker is shared by every context derived from WithReadYourWrites and remembers
+// the highest WAL position written through them.
+type lsnTracker struct {
+	lsn atomic.Uint64
+	// writerOnly is set when the position of a write could not be captured.
+	writerOnly atomic.Bool
+}
+
+func (t *lsnTracker) advance(lsn LSN) {
+	for {
+		cur := t.lsn.Load()
+		if uint64(lsn) <= cur || t.lsn.CompareAndSwap(cur, uint64(lsn)) {
+			return
+		}
+	}
+}
+
+type lsnKey struct{}
+
+var lsnMarkerKey = &lsnKey{}
+
+// WithReadYourWrites returns a context in which writes made through a writer pool record their WAL position,
+// so that subsequent reads routed by Cluster wait for a replica to replay it or fall back to the writer.
+// The position is recorded after committed transactions, and after statements that may modify data
+// once Exec returns, Query rows are read to the end or closed, a QueryRow row is scanned or SendBatch results are closed.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	if extractLSNTracker(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, lsnMarkerKey, &lsnTracker{})
+}
+
+// ContextWithLSN is like WithReadYourWrites but starts from a token previously obtained
+// by LSNFromContext, e.g. passed between requests of the same user.
+func ContextWithLSN(ctx context.Context, lsn LSN) context.Context {
+	ctx = WithReadYourWrites(ctx)
+	extractLSNTracker(ctx).advance(lsn)
+	return ctx
+}
+
+// LSNFromContext returns the highest WAL position written through ctx.
+func LSNFromContext(ctx context.Context) (LSN, bool) {
+	t := extractLSNTracker(ctx)
+	if t == nil || t.lsn.Load() == 0 {
+		return 0, false
+	}
+	return LSN(t.lsn.Load()), true
+}
+
+func extractLSNTracker(ctx context.Context) *lsnTracker {
+	t, _ := ctx.Value(lsnMarkerKey).(*lsnTracker)
+	return t
+}
+
+// captureLSN records the current WAL position of the writer if ctx tracks writes.
+func (p *Pool) captureLSN(ctx context.Context) {
+	t := extractLSNTracker(ctx)
+	if t == nil || !p.cfg.writer {
+		return
+	}
+
+	var raw string
+	err := p.Pool.QueryRow(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&raw)
+	if err == nil {
+		var lsn LSN
+		if lsn, err = ParseLSN(raw); err == nil {
+			t.advance(lsn)
+			return
+		}
+	}
+
+	t.writerOnly.Store(true)
+	p.Logger().WarnContext(ctx, "failed to capture wal position, reads will use the writer", pgxslog.Error(err))
+}
+
+// tracksWrite reports whether the WAL position should be captured after sql, which may modify data, completes.
+func (p *Pool) tracksWrite(ctx context.Context, sql string) bool {
+	return p.cfg.writer && extractLSNTracker(ctx) != nil && !isReadOnlySQL(sql)
+}
+
+// lsnRows captures the WAL position once the rows of a write, e.g. INSERT ... RETURNING, are read to the end
+// or closed. pgx closes the rows itself when Next returns false, so both are watched.
+type lsnRows struct {
+	pgx.Rows
+	pool     *Pool
+	ctx      context.Context
+	captured bool
+}
+
+func (r *lsnRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.capture()
+	return false
+}
+
+func (r *lsnRows) Close() {
+	r.Rows.Close()
+	r.capture()
+}
+
+func (r *lsnRows) capture() {
+	if r.captured {
+		return
+	}
+	r.captured = true
+	if r.Rows.Err() == nil {
+		r.pool.captureLSN(r.ctx)
+	}
+}
+
+// lsnRow captures the WAL position once the row of a write is scanned.
+type lsnRow struct {
+	pgx.Row
+	pool *Pool
+	ctx  context.Context
+}
+
+func (r *lsnRow) Scan(dest ...any) error {
+	err := r.Row.Scan(dest...)
+	if err == nil || errors.Is(err, pgx.ErrNoRows) {
+		r.pool.captureLSN(r.ctx)
+	}
+	return err
+}
+
+// lsnBatchResults captures the WAL position once a batch containing writes is closed.
+type lsnBatchResults struct {
+	pgx.BatchResults
+	pool   *Pool
+	ctx    context.Context
+	closed bool
+}
+
+func (b *lsnBatchResults) Close() error {
+	err := b.BatchResults.Close()
+	if !b.closed && err == nil {
+		b.pool.captureLSN(b.ctx)
+	}
+	b.closed = true
+	return err
+}
+
+const replayPollInterval = 10 * time.Millisecond
+
+// replayLSN queries and caches the WAL position replayed by the replica.
+func (r *replica) replayLSN(ctx context.Context) (LSN, error) {
+	var raw string
+	if err := r.pool.Pool.QueryRow(ctx, "SELECT pg_last_wal_replay_lsn()::text").Scan(&raw); err != nil {
+		return 0, err
+	}
+
+	lsn, err := ParseLSN(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	r.replayed.Store(uint64(lsn))
+	return lsn, nil
+}
+
+// consistentReader returns a replica that has replayed the WAL position tracked by ctx,
+// waiting up to Config.ReadYourWritesTimeout for one to catch up before falling back to the writer.
+func (c *Cluster) consistentReader(ctx context.Context, t *lsnTracker) *Pool {
+	if t.writerOnly.Load() {
+		return c.writer
+	}
+
+	target := t.lsn.Load()
+	if target == 0 {
+		return c.Reader()
+	}
+
+	deadline := time.Now().Add(c.writer.cfg.getReadYourWritesTimeout())
+	for {
+		candidates := c.availableReplicas()
+		if len(candidates) == 0 {
+			return c.writer
+		}
+
+		caughtUp := make([]*replica, 0, len(candidates))
+		for _, r := range candidates {
+			if r.replayed.Load() >= target {
+				caughtUp = append(caughtUp, r)
+				continue
+			}
+			if lsn, err := r.replayLSN(ctx); err == nil && uint64(lsn) >= target {
+				caughtUp = append(caughtUp, r)
+			}
+		}
+		if len(caughtUp) > 0 {
+			return c.balancer.pick(caughtUp).pool
+		}
+
+		if time.Now().Add(replayPollInterval).After(deadline) {
+			c.writer.Logger().DebugContext(ctx, "replicas did not catch up, reading from the writer",
+				slog.String("lsn", LSN(target).String()))
+			return c.writer
+		}
+
+		select {
+		case <-ctx.Done():
+			return c.writer
+		case <-time.After(replayPollInterval):
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PasswordProvider returns the current password of the database user, e.g. fetched from a secrets manager.
+type PasswordProvider func(ctx context.Context) (string, error)
+
+// FilePasswordProvider reads the password from a file and re-reads it whenever the file changes,
+// which suits secrets mounted into Kubernetes pods. Trailing newlines are trimmed.
+func FilePasswordProvider(path string) PasswordProvider {
+	var (
+		mu      sync.Mutex
+		modTime time.Time
+		size    int64
+		value   string
+	)
+
+	return func(context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+
+		if value != "" && info.ModTime().Equal(modTime) && info.Size() == size {
+			return value, nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		modTime, size = info.ModTime(), info.Size()
+		value = strings.TrimRight(string(data), "\r\n")
+		return value, nil
+	}
+}
+
+// passwordRetryDelay is the longest time the provider is not called again after it failed.
+const passwordRetryDelay = 5 * time.Second
+
+// passwordCache calls the provider at most once per refresh interval.
+// If the provider fails, the last known password keeps being used and the provider is not called again
+// for a while. A password rejected by the server is dropped, so a rotated one is fetched right away.
+type passwordCache struct {
+	provider PasswordProvider
+	interval time.Duration
+	pool     *Pool
+	failures prometheus.Counter
+
+	mu        sync.Mutex
+	value     string
+	fetchedAt time.Time
+	// retryAt and err hold the time the failed provider may be called again and its error.
+	retryAt time.Time
+	err     error
+}
+
+func (p *Pool) newPasswordCache() *passwordCache {
+	return &passwordCache{
+		provider: p.passwordProvider,
+		interval: p.passwordRefresh,
+		pool:     p,
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   p.namespace,
+			Subsystem:   "postgres",
+			Name:        "password_provider_failures_total",
+			Help:        "Number of failed password provider calls.",
+			ConstLabels: p.labels,
+		}),
+	}
+}
+
+func (c *passwordCache) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.value != "" && time.Since(c.fetchedAt) < c.interval {
+		return c.value, nil
+	}
+	if time.Now().Before(c.retryAt) {
+		if c.value == "" {
+			return "", c.err
+		}
+		return c.value, nil
+	}
+
+	value, err := c.provider(ctx)
+	if err != nil {
+		c.failures.Inc()
+		c.retryAt, c.err = time.Now().Add(min(c.interval, passwordRetryDelay)), err
+		if c.value == "" {
+			return "", err
+		}
+		c.pool.Logger().WarnContext(ctx, "password provider failed, using the last known password", pgxslog.Error(err))
+		return c.value, nil
+	}
+
+	c.value, c.fetchedAt = value, time.Now()
+	c.retryAt, c.err = time.Time{}, nil
+	return value, nil
+}
+
+// invalidate drops the cached password, so the next connection fetches a new one.
+func (c *passwordCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.value, c.fetchedAt = "", time.Time{}
+}
+
+func (c *passwordCache) TraceConnectStart(ctx context.Context, _ pgx.TraceConnectStartData) context.Context {
+	return ctx
+}
+
+// TraceConnectEnd drops the cached password once the server rejects it, e.g. after a rotation.
+func (c *passwordCache) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	var pgErr *pgconn.PgError
+	if errors.As(data.Err, &pgErr) && pgErr.Code == pgerrcode.InvalidPassword {
+		c.pool.Logger().InfoContext(ctx, "password rejected, fetching a new one")
+		c.invalidate()
+	}
+}
+
+// beforeConnect sets the current password on every new connection.
+func (c *passwordCache) beforeConnect(ctx context.Context, cfg *pgx.ConnConfig) error {
+	password, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	cfg.Password = password
+	return nil
+}
@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadConfigFromEnv reads Config from the environment variables named in its envconfig tags.
+//
+// A non-empty prefix is joined to every variable name with an underscore, so several databases
+// can be configured in one process: prefix "ORDERS" reads ORDERS_POSTGRES_CLUSTER_HOST and so on.
+// If a variable is not set, the content of the file named by the same variable with the _FILE suffix
+// is used instead, which suits mounted secrets. Durations use time.ParseDuration syntax, lists are comma-separated.
+//
+// All missing required variables and malformed values are reported at once.
+func LoadConfigFromEnv(prefix string) (*Config, error) {
+	cfg := &Config{}
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	var errs []error
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		name, ok := field.Tag.Lookup("envconfig")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		value, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if value == "" {
+			if field.Tag.Get("required") == "true" {
+				errs = append(errs, fmt.Errorf("required variable %s is not set", name))
+			}
+			continue
+		}
+
+		if err := setField(v.Field(i), value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value of %s: %w", name, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// lookupEnv returns the value of the variable, falling back to the content of the file named by <name>_FILE.
+func lookupEnv(name string) (string, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	}
+
+	path, ok := os.LookupEnv(name + "_FILE")
+	if !ok || path == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		items := strings.Split(value, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		field.Set(reflect.ValueOf(items))
+
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
@@ -22,23 +22,53 @@ const (
 	ErrBeginTransaction
 	ErrCommitTransaction
 	ErrNoConnection
+	ErrReadOnlyTransaction
+	ErrDeadlock
 )
 
+var pgErrorCodeNames = [...]string{
+	ErrContextDeadline:     "context_deadline",
+	ErrNoRows:              "no_rows",
+	ErrUniqViolation:       "uniq_violation",
+	ErrForeignKeyViolation: "foreign_key_violation",
+	ErrSerializable:        "serializable",
+	ErrOther:               "other",
+	ErrBeginTransaction:    "begin_transaction",
+	ErrCommitTransaction:   "commit_transaction",
+	ErrNoConnection:        "no_connection",
+	ErrReadOnlyTransaction: "read_only_transaction",
+	ErrDeadlock:            "deadlock",
+}
+
+// String returns the snake_case name of the code, as used in metric labels.
+func (c PgErrorCode) String() string {
+	if c < 0 || int(c) >= len(pgErrorCodeNames) {
+		return "unknown"
+	}
+	return pgErrorCodeNames[c]
+}
+
 type PgError struct {
 	code PgErrorCode
 	msg  string
+	err  error
 }
 
 func (e PgError) Error() string {
 	return e.msg
 }
 
+// Unwrap returns the original error, so errors.As still finds e.g. the *pgconn.PgError.
+func (e PgError) Unwrap() error {
+	return e.err
+}
+
 func (e PgError) Code() PgErrorCode {
 	return e.code
 }
 
 func NewPgError(code PgErrorCode, err error) *PgError {
-	return &PgError{code, err.Error()}
+	return &PgError{code, err.Error(), err}
 }
 
 func ConvertError(err error) *PgError {
@@ -69,9 +99,11 @@ func ConvertError(err error) *PgError {
 }
 
 var pgCodeMap = map[string]PgErrorCode{
-	pgerrcode.UniqueViolation:      ErrUniqViolation,
-	pgerrcode.ForeignKeyViolation:  ErrForeignKeyViolation,
-	pgerrcode.SerializationFailure: ErrSerializable,
+	pgerrcode.UniqueViolation:        ErrUniqViolation,
+	pgerrcode.ForeignKeyViolation:    ErrForeignKeyViolation,
+	pgerrcode.SerializationFailure:   ErrSerializable,
+	pgerrcode.ReadOnlySQLTransaction: ErrReadOnlyTransaction,
+	pgerrcode.DeadlockDetected:       ErrDeadlock,
 }
 
 func pgCodeToError(code string) PgErrorCode {
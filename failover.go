@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// failoverDebounce prevents queries failing on several connections of the demoted primary at once
+// from resetting the pool over and over again.
+const failoverDebounce = time.Second
+
+// failoverConfirmTimeout bounds the pg_is_in_recovery check confirming a demotion.
+const failoverConfirmTimeout = 5 * time.Second
+
+// failoverTracer watches writer queries for read-only transaction errors,
+// which mean the primary the connection points to may have been demoted.
+type failoverTracer struct {
+	pool *Pool
+	ctx  context.Context
+	// confirming allows a single pg_is_in_recovery check at a time.
+	confirming atomic.Bool
+}
+
+func (t *failoverTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+// TraceQueryEnd confirms the demotion before failing over, since a healthy primary rejects writes
+// inside a READ ONLY transaction with the same error. The check runs in the background,
+// as the failed query still holds its connection.
+func (t *failoverTracer) TraceQueryEnd(_ context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if !isReadOnlyError(data.Err) || !t.confirming.CompareAndSwap(false, true) {
+		return
+	}
+
+	started := t.pool.goBackground(func() {
+		defer t.confirming.Store(false)
+
+		ctx, cancel := context.WithTimeout(t.ctx, failoverConfirmTimeout)
+		defer cancel()
+
+		inRecovery, err := t.pool.inRecovery(ctx)
+		switch {
+		case err != nil:
+			t.pool.Logger().DebugContext(ctx, "failed to check writer recovery state", pgxslog.Error(err))
+		case inRecovery:
+			t.pool.failover(t.ctx, "read-only transaction")
+		}
+	})
+	if !started {
+		t.confirming.Store(false)
+	}
+}
+
+func isReadOnlyError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ReadOnlySQLTransaction
+}
+
+func (p *Pool) newFailoverCounter() prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   p.namespace,
+		Subsystem:   "postgres",
+		Name:        "failovers_total",
+		Help:        "Number of times the writer detected a demoted primary and reconnected.",
+		ConstLabels: p.labels,
+	})
+}
+
+// failover drains every connection of the writer. Connections checked out at the moment are closed
+// once released, new ones go through target_session_attrs=read-write and land on the current primary.
+func (p *Pool) failover(ctx context.Context, reason string) {
+	now := time.Now().UnixNano()
+	last := p.lastFailover.Load()
+	if now-last < int64(failoverDebounce) || !p.lastFailover.CompareAndSwap(last, now) {
+		return
+	}
+
+	p.Logger().WarnContext(ctx, "primary demoted, reconnecting writer",
+		slog.String("reason", reason),
+		slog.String("endpoint", p.cfg.getEndpoint()))
+
+	if p.failovers != nil {
+		p.failovers.Inc()
+	}
+	p.Pool.Reset()
+}
+
+// checkRecoveryLoop periodically asks the writer whether it is in recovery,
+// so a failover is detected even if no write hits the demoted node.
+func (p *Pool) checkRecoveryLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			inRecovery, err := p.inRecovery(checkCtx)
+			cancel()
+
+			switch {
+			case err != nil:
+				p.Logger().DebugContext(ctx, "failed to check writer recovery state", pgxslog.Error(err))
+			case inRecovery:
+				p.failover(ctx, "pg_is_in_recovery")
+			}
+		}
+	}
+}
+
+func (p *Pool) inRecovery(ctx context.Context) (bool, error) {
+	var inRecovery bool
+	err := p.Pool.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	return inRecovery, err
+}
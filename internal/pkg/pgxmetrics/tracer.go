@@ -0,0 +1,294 @@
+// Package pgxmetrics provides a pgx QueryTracer exporting prometheus metrics of queries.
+//
+// This package tracks the following metrics under the following names:
+//
+//	#ns_postgres_query_duration_seconds{operation, query}
+//	#ns_postgres_query_errors_total{operation, query, code}
+//
+// operation is one of select, insert, update, delete or other. query is the name from an sqlc
+// "-- name: GetUser :one" comment, otherwise the operation and the first table, e.g. select_users.
+// Once the number of distinct query names reaches the limit, new ones are reported as "other".
+package pgxmetrics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultMaxQueries limits the number of distinct query label values.
+	DefaultMaxQueries = 200
+
+	// otherLabel replaces the query names above the limit and unknown operations.
+	otherLabel = "other"
+)
+
+// Option configures a Tracer.
+type Option func(t *Tracer)
+
+// WithBuckets sets the buckets of the duration histogram, prometheus.DefBuckets if not set.
+func WithBuckets(buckets []float64) Option {
+	return func(t *Tracer) {
+		if len(buckets) > 0 {
+			t.buckets = buckets
+		}
+	}
+}
+
+// WithMaxQueries limits the number of distinct query names, DefaultMaxQueries if not set.
+func WithMaxQueries(n int) Option {
+	return func(t *Tracer) {
+		if n > 0 {
+			t.maxQueries = n
+		}
+	}
+}
+
+// WithErrorClassifier sets the function turning a query error into the code label.
+func WithErrorClassifier(fn func(err error) string) Option {
+	return func(t *Tracer) {
+		if fn != nil {
+			t.classify = fn
+		}
+	}
+}
+
+// Tracer implements pgx.QueryTracer and prometheus.Collector.
+type Tracer struct {
+	buckets    []float64
+	maxQueries int
+	classify   func(err error) string
+
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+
+	mu      sync.RWMutex
+	queries map[string]struct{}
+}
+
+type queryKey struct{}
+
+var queryMarkerKey = &queryKey{}
+
+type queryStart struct {
+	sql   string
+	start time.Time
+}
+
+// New creates a new Tracer.
+func New(namespace, subsystem string, constLabels prometheus.Labels, opts ...Option) *Tracer {
+	t := &Tracer{
+		buckets:    prometheus.DefBuckets,
+		maxQueries: DefaultMaxQueries,
+		classify:   func(error) string { return "error" },
+		queries:    make(map[string]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	t.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Subsystem:   subsystem,
+		Name:        "query_duration_seconds",
+		Help:        "Duration of queries.",
+		ConstLabels: constLabels,
+		Buckets:     t.buckets,
+	}, []string{"operation", "query"})
+
+	t.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   subsystem,
+		Name:        "query_errors_total",
+		Help:        "Number of failed queries.",
+		ConstLabels: constLabels,
+	}, []string{"operation", "query", "code"})
+
+	return t
+}
+
+func (t *Tracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryMarkerKey, queryStart{sql: data.SQL, start: time.Now()})
+}
+
+func (t *Tracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(queryMarkerKey).(queryStart)
+	if !ok {
+		return
+	}
+
+	operation, name := Classify(q.sql)
+	name = t.limit(name)
+
+	t.duration.WithLabelValues(operation, name).Observe(time.Since(q.start).Seconds())
+	if data.Err != nil {
+		t.errors.WithLabelValues(operation, name, t.classify(data.Err)).Inc()
+	}
+}
+
+// limit returns name while the number of distinct names is below the limit, otherwise "other".
+func (t *Tracer) limit(name string) string {
+	t.mu.RLock()
+	_, known := t.queries[name]
+	t.mu.RUnlock()
+	if known {
+		return name
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, known = t.queries[name]; known {
+		return name
+	}
+	if len(t.queries) >= t.maxQueries {
+		return otherLabel
+	}
+	t.queries[name] = struct{}{}
+	return name
+}
+
+// Describe implements the prometheus.Collector interface.
+func (t *Tracer) Describe(ch chan<- *prometheus.Desc) {
+	t.duration.Describe(ch)
+	t.errors.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (t *Tracer) Collect(ch chan<- prometheus.Metric) {
+	t.duration.Collect(ch)
+	t.errors.Collect(ch)
+}
+
+var operations = map[string]bool{
+	"select": true,
+	"insert": true,
+	"update": true,
+	"delete": true,
+}
+
+// Classify returns the operation and the normalized name of the query.
+func Classify(sql string) (operation, name string) {
+	operation = otherLabel
+	words := tokenize(StripLeadingComments(sql))
+
+	var table string
+	depth := 0
+loop:
+	for i, w := range words {
+		switch {
+		case w == "(":
+			depth++
+			continue
+		case w == ")":
+			depth--
+			continue
+		case depth > 0:
+			// CTE bodies and subqueries.
+			continue
+		}
+
+		next := ""
+		if i+1 < len(words) && words[i+1] != "(" && words[i+1] != ")" {
+			next = words[i+1]
+		}
+
+		switch {
+		case operation == otherLabel && operations[w]:
+			// the main statement of a WITH query follows its CTEs.
+			operation = w
+			if w == "update" {
+				table = next
+				break loop
+			}
+		case operation == otherLabel:
+			if i == 0 && w != "with" {
+				break loop
+			}
+		case w == "from" || w == "into":
+			table = next
+			break loop
+		}
+	}
+
+	if name = sqlcName(sql); name != "" {
+		return operation, name
+	}
+	if table != "" {
+		return operation, operation + "_" + table
+	}
+	return operation, operation
+}
+
+// tokenize splits sql into lower case identifiers and parentheses, dropping quotes and everything else.
+func tokenize(sql string) []string {
+	var (
+		words []string
+		word  strings.Builder
+	)
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range strings.ToLower(sql) {
+		switch {
+		case r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			word.WriteRune(r)
+		case r == '(' || r == ')':
+			flush()
+			words = append(words, string(r))
+		case r == '"':
+			// quoted identifiers are reported by their name.
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return words
+}
+
+// sqlcName returns the query name from a leading "-- name: <Name> :<kind>" comment generated by sqlc.
+func sqlcName(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if !strings.HasPrefix(sql, "-- name:") {
+		return ""
+	}
+	fields := strings.Fields(strings.TrimPrefix(sql, "-- name:"))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// StripLeadingComments removes the comments and whitespace sql starts with.
+func StripLeadingComments(sql string) string {
+	for {
+		sql = strings.TrimSpace(sql)
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			i := strings.IndexByte(sql, '\n')
+			if i < 0 {
+				return ""
+			}
+			sql = sql[i+1:]
+		case strings.HasPrefix(sql, "/*"):
+			i := strings.Index(sql, "*/")
+			if i < 0 {
+				return ""
+			}
+			sql = sql[i+2:]
+		default:
+			return sql
+		}
+	}
+}
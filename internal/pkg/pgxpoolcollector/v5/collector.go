@@ -2,20 +2,33 @@
 //
 // This package tracks the following metrics under the following names:
 //
-//	#ns_postgres_acquire_count{}
-//	#ns_postgres_acquire_duration{}
+//	#ns_postgres_acquire_total{}
+//	#ns_postgres_acquire_duration_seconds_total{}
 //	#ns_postgres_acquired_conns{}
-//	#ns_postgres_canceled_acquire_count{}
+//	#ns_postgres_canceled_acquire_total{}
 //	#ns_postgres_constructing_conns{}
-//	#ns_postgres_empty_acquire_count{}
+//	#ns_postgres_empty_acquire_total{}
+//	#ns_postgres_empty_acquire_wait_seconds_total{}
 //	#ns_postgres_idle_conns{}
 //	#ns_postgres_max_conns{}
 //	#ns_postgres_total_conns{}
+//	#ns_postgres_new_conns_total{}
+//	#ns_postgres_max_lifetime_destroy_total{}
+//	#ns_postgres_max_idle_destroy_total{}
+//
+// With WithLegacyNames the following gauges are exported as well, with the values they had
+// before the cumulative stats became counters (acquire_duration is in nanoseconds):
+//
+//	#ns_postgres_acquire_count{}
+//	#ns_postgres_acquire_duration{}
+//	#ns_postgres_canceled_acquire_count{}
+//	#ns_postgres_empty_acquire_count{}
 //
 // Labels list:
 //	client_id=#{client_id}
 //	client_kind=#{master/replica}
 //	db=#{db}
+//	endpoint=#{host:port}
 //	shard_id=#{shard_id}
 
 package v5
@@ -31,94 +44,120 @@ type StatsGetter interface {
 	Stat() *pgxpool.Stat
 }
 
+// Option configures a StatsCollector.
+type Option func(c *StatsCollector)
+
+// WithLegacyNames additionally exports the cumulative stats under their old gauge names,
+// so dashboards keep working while they are migrated to the counters.
+func WithLegacyNames() Option {
+	return func(c *StatsCollector) {
+		c.legacy = true
+	}
+}
+
 // StatsCollector implements the prometheus.Collector interface.
 type StatsCollector struct {
-	sg StatsGetter
+	sg     StatsGetter
+	legacy bool
 
 	// descriptions of exported metrics
-	acquireCount         *prometheus.Desc
-	acquireDuration      *prometheus.Desc
-	acquiredConns        *prometheus.Desc
-	canceledAcquireCount *prometheus.Desc
-	constructingConns    *prometheus.Desc
-	emptyAcquireCount    *prometheus.Desc
-	idleConns            *prometheus.Desc
-	maxConns             *prometheus.Desc
-	totalConns           *prometheus.Desc
+	acquireTotal            *prometheus.Desc
+	acquireDuration         *prometheus.Desc
+	acquiredConns           *prometheus.Desc
+	canceledAcquireTotal    *prometheus.Desc
+	constructingConns       *prometheus.Desc
+	emptyAcquireTotal       *prometheus.Desc
+	emptyAcquireWaitTime    *prometheus.Desc
+	idleConns               *prometheus.Desc
+	maxConns                *prometheus.Desc
+	totalConns              *prometheus.Desc
+	newConnsTotal           *prometheus.Desc
+	maxLifetimeDestroyTotal *prometheus.Desc
+	maxIdleDestroyTotal     *prometheus.Desc
+
+	// descriptions of legacy metrics
+	legacyAcquireCount         *prometheus.Desc
+	legacyAcquireDuration      *prometheus.Desc
+	legacyCanceledAcquireCount *prometheus.Desc
+	legacyEmptyAcquireCount    *prometheus.Desc
 }
 
 // NewStatsCollector creates a new StatsCollector.
-func NewStatsCollector(namespace, subsystem string, constLabels prometheus.Labels, sg StatsGetter) *StatsCollector {
-	return &StatsCollector{
+func NewStatsCollector(namespace, subsystem string, constLabels prometheus.Labels, sg StatsGetter, opts ...Option) *StatsCollector {
+	newDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, nil, constLabels)
+	}
+
+	c := &StatsCollector{
 		sg: sg,
-		acquireCount: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "acquire_count"),
-			"Cumulative count of successful acquires from the pool.",
-			nil,
-			constLabels,
-		),
-		acquireDuration: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "acquire_duration"),
-			"Total duration of all successful acquires from the pool.",
-			nil,
-			constLabels,
-		),
-		acquiredConns: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "acquired_conns"),
-			"Number of currently acquired connections in the pool.",
-			nil,
-			constLabels,
-		),
-		canceledAcquireCount: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "canceled_acquire_count"),
-			"Cumulative count of acquires from the pool that were canceled by a context.",
-			nil,
-			constLabels,
-		),
-		constructingConns: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "constructing_conns"),
-			"Number of conns with construction in progress in the pool.",
-			nil,
-			constLabels,
-		),
-		emptyAcquireCount: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "empty_acquire_count"),
-			"Cumulative count of successful acquires from the pool that waited for a resource to be released or constructed because the pool was empty.",
-			nil,
-			constLabels,
-		),
-		idleConns: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "idle_conns"),
-			"Number of currently idle conns in the pool.",
-			nil,
-			constLabels,
-		),
-		maxConns: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "max_conns"),
-			"Maximum size of the pool.",
-			nil,
-			constLabels,
-		),
-		totalConns: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "total_conns"),
-			"Total number of resources currently in the pool. The value is the sum of ConstructingConns, AcquiredConns, and IdleConns.",
-			nil,
-			constLabels,
-		),
+		acquireTotal: newDesc("acquire_total",
+			"Cumulative count of successful acquires from the pool."),
+		acquireDuration: newDesc("acquire_duration_seconds_total",
+			"Total duration of all successful acquires from the pool."),
+		acquiredConns: newDesc("acquired_conns",
+			"Number of currently acquired connections in the pool."),
+		canceledAcquireTotal: newDesc("canceled_acquire_total",
+			"Cumulative count of acquires from the pool that were canceled by a context."),
+		constructingConns: newDesc("constructing_conns",
+			"Number of conns with construction in progress in the pool."),
+		emptyAcquireTotal: newDesc("empty_acquire_total",
+			"Cumulative count of successful acquires from the pool that waited for a resource to be released or constructed because the pool was empty."),
+		emptyAcquireWaitTime: newDesc("empty_acquire_wait_seconds_total",
+			"Cumulative time waited for successful acquires from the pool for a resource to be released or constructed because the pool was empty."),
+		idleConns: newDesc("idle_conns",
+			"Number of currently idle conns in the pool."),
+		maxConns: newDesc("max_conns",
+			"Maximum size of the pool."),
+		totalConns: newDesc("total_conns",
+			"Total number of resources currently in the pool. The value is the sum of ConstructingConns, AcquiredConns, and IdleConns."),
+		newConnsTotal: newDesc("new_conns_total",
+			"Cumulative count of new connections opened."),
+		maxLifetimeDestroyTotal: newDesc("max_lifetime_destroy_total",
+			"Cumulative count of connections destroyed because they exceeded MaxConnLifetime."),
+		maxIdleDestroyTotal: newDesc("max_idle_destroy_total",
+			"Cumulative count of connections destroyed because they exceeded MaxConnIdleTime."),
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.legacy {
+		c.legacyAcquireCount = newDesc("acquire_count",
+			"Deprecated: use acquire_total. Cumulative count of successful acquires from the pool.")
+		c.legacyAcquireDuration = newDesc("acquire_duration",
+			"Deprecated: use acquire_duration_seconds_total. Total duration of all successful acquires from the pool in nanoseconds.")
+		c.legacyCanceledAcquireCount = newDesc("canceled_acquire_count",
+			"Deprecated: use canceled_acquire_total. Cumulative count of acquires from the pool that were canceled by a context.")
+		c.legacyEmptyAcquireCount = newDesc("empty_acquire_count",
+			"Deprecated: use empty_acquire_total. Cumulative count of successful acquires from the pool that waited for a resource.")
+	}
+
+	return c
 }
 
 // Describe implements the prometheus.Collector interface.
 func (c StatsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.acquireCount
+	ch <- c.acquireTotal
 	ch <- c.acquireDuration
 	ch <- c.acquiredConns
-	ch <- c.canceledAcquireCount
+	ch <- c.canceledAcquireTotal
 	ch <- c.constructingConns
-	ch <- c.emptyAcquireCount
+	ch <- c.emptyAcquireTotal
+	ch <- c.emptyAcquireWaitTime
 	ch <- c.idleConns
 	ch <- c.maxConns
 	ch <- c.totalConns
+	ch <- c.newConnsTotal
+	ch <- c.maxLifetimeDestroyTotal
+	ch <- c.maxIdleDestroyTotal
+
+	if c.legacy {
+		ch <- c.legacyAcquireCount
+		ch <- c.legacyAcquireDuration
+		ch <- c.legacyCanceledAcquireCount
+		ch <- c.legacyEmptyAcquireCount
+	}
 }
 
 // Collect implements the prometheus.Collector interface.
@@ -126,15 +165,15 @@ func (c StatsCollector) Collect(ch chan<- prometheus.Metric) {
 	stats := c.sg.Stat()
 
 	ch <- prometheus.MustNewConstMetric(
-		c.acquireCount,
-		prometheus.GaugeValue,
+		c.acquireTotal,
+		prometheus.CounterValue,
 		float64(stats.AcquireCount()),
 	)
 
 	ch <- prometheus.MustNewConstMetric(
 		c.acquireDuration,
-		prometheus.GaugeValue,
-		float64(stats.AcquireDuration()),
+		prometheus.CounterValue,
+		stats.AcquireDuration().Seconds(),
 	)
 
 	ch <- prometheus.MustNewConstMetric(
@@ -144,8 +183,8 @@ func (c StatsCollector) Collect(ch chan<- prometheus.Metric) {
 	)
 
 	ch <- prometheus.MustNewConstMetric(
-		c.canceledAcquireCount,
-		prometheus.GaugeValue,
+		c.canceledAcquireTotal,
+		prometheus.CounterValue,
 		float64(stats.CanceledAcquireCount()),
 	)
 
@@ -156,11 +195,17 @@ func (c StatsCollector) Collect(ch chan<- prometheus.Metric) {
 	)
 
 	ch <- prometheus.MustNewConstMetric(
-		c.emptyAcquireCount,
-		prometheus.GaugeValue,
+		c.emptyAcquireTotal,
+		prometheus.CounterValue,
 		float64(stats.EmptyAcquireCount()),
 	)
 
+	ch <- prometheus.MustNewConstMetric(
+		c.emptyAcquireWaitTime,
+		prometheus.CounterValue,
+		stats.EmptyAcquireWaitTime().Seconds(),
+	)
+
 	ch <- prometheus.MustNewConstMetric(
 		c.idleConns,
 		prometheus.GaugeValue,
@@ -178,4 +223,48 @@ func (c StatsCollector) Collect(ch chan<- prometheus.Metric) {
 		prometheus.GaugeValue,
 		float64(stats.TotalConns()),
 	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.newConnsTotal,
+		prometheus.CounterValue,
+		float64(stats.NewConnsCount()),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.maxLifetimeDestroyTotal,
+		prometheus.CounterValue,
+		float64(stats.MaxLifetimeDestroyCount()),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.maxIdleDestroyTotal,
+		prometheus.CounterValue,
+		float64(stats.MaxIdleDestroyCount()),
+	)
+
+	if c.legacy {
+		ch <- prometheus.MustNewConstMetric(
+			c.legacyAcquireCount,
+			prometheus.GaugeValue,
+			float64(stats.AcquireCount()),
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.legacyAcquireDuration,
+			prometheus.GaugeValue,
+			float64(stats.AcquireDuration()),
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.legacyCanceledAcquireCount,
+			prometheus.GaugeValue,
+			float64(stats.CanceledAcquireCount()),
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.legacyEmptyAcquireCount,
+			prometheus.GaugeValue,
+			float64(stats.EmptyAcquireCount()),
+		)
+	}
 }
@@ -3,19 +3,65 @@ package pgxslog
 import (
 	"context"
 	"log/slog"
+	"math/rand/v2"
 
 	"github.com/jackc/pgx/v5/tracelog"
 )
 
+// Redactor rewrites the SQL text and the arguments of a query before they are logged.
+// Returning nil args removes them from the record.
+type Redactor func(sql string, args []any) (string, []any)
+
 type Logger struct {
-	l *slog.Logger
+	l          *slog.Logger
+	redact     Redactor
+	sampleRate float64
+}
+
+type LoggerOption func(l *Logger)
+
+// WithRedactor applies r to the "sql" and "args" fields of every record.
+func WithRedactor(r Redactor) LoggerOption {
+	return func(l *Logger) {
+		l.redact = r
+	}
+}
+
+// WithDebugSampling keeps only the given fraction of debug and trace records, e.g. 0.1 for 10%.
+// Records of higher levels are always kept.
+func WithDebugSampling(rate float64) LoggerOption {
+	return func(l *Logger) {
+		if rate > 0 && rate < 1 {
+			l.sampleRate = rate
+		}
+	}
 }
 
-func NewLogger(l *slog.Logger) *Logger {
-	return &Logger{l}
+func NewLogger(l *slog.Logger, opts ...LoggerOption) *Logger {
+	logger := &Logger{l: l}
+	for _, opt := range opts {
+		opt(logger)
+	}
+	return logger
 }
 
 func (l *Logger) Log(ctx context.Context, level tracelog.LogLevel, msg string, data map[string]interface{}) {
+	if l.sampleRate > 0 && level >= tracelog.LogLevelDebug && rand.Float64() >= l.sampleRate {
+		return
+	}
+
+	if sql, ok := data["sql"].(string); ok && l.redact != nil {
+		args, _ := data["args"].([]any)
+		sql, args = l.redact(sql, args)
+
+		data["sql"] = sql
+		if args == nil {
+			delete(data, "args")
+		} else {
+			data["args"] = args
+		}
+	}
+
 	attrs := make([]slog.Attr, 0, len(data))
 	for k, v := range data {
 		attrs = append(attrs, slog.Any(k, v))
@@ -38,3 +38,24 @@ func New(tracers ...QueryTracer) QueryTracer {
 		tracers: tracers,
 	}
 }
+
+type connectTracer struct {
+	QueryTracer
+	connect pgx.ConnectTracer
+}
+
+func (t *connectTracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	return t.connect.TraceConnectStart(ctx, data)
+}
+
+func (t *connectTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	t.connect.TraceConnectEnd(ctx, data)
+}
+
+// WithConnectTracer makes the query tracer trace connection attempts with connect as well.
+func WithConnectTracer(tracer QueryTracer, connect pgx.ConnectTracer) QueryTracer {
+	return &connectTracer{
+		QueryTracer: tracer,
+		connect:     connect,
+	}
+}
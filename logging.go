@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/tracelog"
+	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
+)
+
+// LogLevelVar is a pgx log level that can be changed while pools are using it,
+// e.g. from an admin endpoint. Share one between pools with WithLogLevelVar.
+type LogLevelVar struct {
+	level atomic.Int32
+}
+
+// NewLogLevelVar creates a LogLevelVar set to level.
+func NewLogLevelVar(level tracelog.LogLevel) *LogLevelVar {
+	v := &LogLevelVar{}
+	v.Set(level)
+	return v
+}
+
+func (v *LogLevelVar) Level() tracelog.LogLevel {
+	return tracelog.LogLevel(v.level.Load())
+}
+
+// Set changes the level, values outside of LogLevelNone..LogLevelTrace are clamped.
+func (v *LogLevelVar) Set(level tracelog.LogLevel) {
+	v.level.Store(int32(min(max(level, tracelog.LogLevelNone), tracelog.LogLevelTrace)))
+}
+
+// leveledTraceLog logs queries through the TraceLog of the current level. TraceLog reads its level
+// without synchronization, so instead of changing it there is one TraceLog per level.
+// They share the context keys, so a query started with one level can end with another.
+type leveledTraceLog struct {
+	level *LogLevelVar
+	logs  [tracelog.LogLevelTrace + 1]*tracelog.TraceLog
+}
+
+func newLeveledTraceLog(level *LogLevelVar, logger *pgxslog.Logger) *leveledTraceLog {
+	t := &leveledTraceLog{level: level}
+	for l := tracelog.LogLevelNone; l <= tracelog.LogLevelTrace; l++ {
+		t.logs[l] = &tracelog.TraceLog{Logger: logger, LogLevel: l}
+	}
+	return t
+}
+
+func (t *leveledTraceLog) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return t.logs[t.level.Level()].TraceQueryStart(ctx, conn, data)
+}
+
+func (t *leveledTraceLog) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.logs[t.level.Level()].TraceQueryEnd(ctx, conn, data)
+}
+
+// LogLevel returns the current pgx log level of the pool.
+func (p *Pool) LogLevel() tracelog.LogLevel {
+	return p.logLevel.Level()
+}
+
+// SetLogLevel changes the pgx log level of the pool, and of every pool sharing its LogLevelVar.
+func (p *Pool) SetLogLevel(level tracelog.LogLevel) {
+	p.logLevel.Set(level)
+}
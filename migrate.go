@@ -4,17 +4,27 @@ import (
 	"context"
 	"embed"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres" // init
+	"github.com/golang-migrate/migrate/v4/database"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
 	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
-func applyMigrations(fs embed.FS, dsn string, l *slog.Logger) (err error) {
+// applyMigrations runs migrations over the pgx driver, so the connection shares
+// TLS and other settings with the pools.
+// The migration is stopped gracefully between two steps once ctx is done.
+func applyMigrations(ctx context.Context, fs embed.FS, connConfig *pgx.ConnConfig, cfg pgxmigrate.Config, l *slog.Logger, opts ...stdlib.OptionOpenDB) (err error) {
 	var src source.Driver
-	ctx := context.Background()
 
 	src, err = iofs.New(fs, ".")
 	if err != nil {
@@ -27,10 +37,19 @@ func applyMigrations(fs embed.FS, dsn string, l *slog.Logger) (err error) {
 		}
 	}()
 
+	db := stdlib.OpenDB(*connConfig, opts...)
+
+	var driver database.Driver
+	driver, err = pgxmigrate.WithInstance(db, &cfg)
+	if err != nil {
+		err = errors.Join(errors.New("db driver init failed"), err, db.Close())
+		return err
+	}
+
 	var instance *migrate.Migrate
-	instance, err = migrate.NewWithSourceInstance("iofs", src, dsn)
+	instance, err = migrate.NewWithInstance("iofs", src, "pgx5", driver)
 	if err != nil {
-		err = errors.Join(errors.New("db instance init failed"), err)
+		err = errors.Join(errors.New("db instance init failed"), err, driver.Close())
 		return err
 	}
 	defer func() {
@@ -39,6 +58,16 @@ func applyMigrations(fs embed.FS, dsn string, l *slog.Logger) (err error) {
 		}
 	}()
 
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			instance.GracefulStop <- true
+		case <-done:
+		}
+	}()
+
 	if mErr := instance.Up(); mErr != nil && !errors.Is(mErr, migrate.ErrNoChange) {
 		err = errors.Join(errors.New("migrate-up failed"), mErr)
 	} else {
@@ -50,3 +79,50 @@ func applyMigrations(fs embed.FS, dsn string, l *slog.Logger) (err error) {
 
 	return err
 }
+
+// splitMigrateArgs removes the golang-migrate "x-*" options from a URL dsn, which the server would
+// reject as runtime parameters, and returns them as the driver config.
+// Key-value dsns are returned as is.
+func splitMigrateArgs(dsn string) (string, pgxmigrate.Config, error) {
+	cfg := pgxmigrate.Config{MultiStatementMaxSize: pgxmigrate.DefaultMultiStatementMaxSize}
+
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return dsn, cfg, nil //nolint:nilerr // not a URL dsn, pgx reports malformed ones.
+	}
+
+	query := u.Query()
+	for key, values := range query {
+		if !strings.HasPrefix(key, "x-") {
+			continue
+		}
+		delete(query, key)
+
+		value := values[len(values)-1]
+		switch key {
+		case "x-migrations-table":
+			cfg.MigrationsTable = value
+		case "x-migrations-table-quoted":
+			cfg.MigrationsTableQuoted, err = strconv.ParseBool(value)
+		case "x-statement-timeout":
+			var ms int
+			ms, err = strconv.Atoi(value)
+			cfg.StatementTimeout = time.Duration(ms) * time.Millisecond
+		case "x-multi-statement":
+			cfg.MultiStatementEnabled, err = strconv.ParseBool(value)
+		case "x-multi-statement-max-size":
+			var size int
+			if size, err = strconv.Atoi(value); size > 0 {
+				cfg.MultiStatementMaxSize = size
+			}
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return "", cfg, errors.Join(fmt.Errorf("invalid migrate option %s", key), err)
+		}
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), cfg, nil
+}
@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestFormatDSN(t *testing.T) {
+	type endpoint struct {
+		host string
+		port uint16
+	}
+
+	tests := []struct {
+		name      string
+		user      string
+		pass      string
+		endpoints []string
+		db        string
+		args      []string
+		want      []endpoint
+		wantTLS   bool
+		wantParam map[string]string
+	}{
+		{
+			name:      "special characters",
+			user:      "app@svc",
+			pass:      "p@ss/w?rd#1:%",
+			endpoints: []string{"127.0.0.1:5432"},
+			db:        "orders",
+			args:      []string{"sslmode=disable"},
+			want:      []endpoint{{"127.0.0.1", 5432}},
+		},
+		{
+			name:      "unix socket",
+			user:      "app",
+			pass:      "secret",
+			endpoints: []string{"/var/run/postgresql:5433"},
+			db:        "orders",
+			want:      []endpoint{{"/var/run/postgresql", 5433}},
+		},
+		{
+			name:      "multiple hosts",
+			user:      "app",
+			pass:      "secret",
+			endpoints: []string{"db-1:5432", "db-2:5433", "[::1]:5434"},
+			db:        "orders",
+			args:      []string{"sslmode=disable", "target_session_attrs=read-write"},
+			want:      []endpoint{{"db-1", 5432}, {"db-2", 5433}, {"::1", 5434}},
+		},
+		{
+			name:      "args override sslmode",
+			user:      "app",
+			pass:      "secret",
+			endpoints: []string{"db:5432"},
+			db:        "orders",
+			args:      []string{"sslmode=disable", "sslmode=require&search_path=app"},
+			want:      []endpoint{{"db", 5432}},
+			wantTLS:   true,
+			wantParam: map[string]string{"search_path": "app"},
+		},
+		{
+			name:      "empty args",
+			user:      "app",
+			pass:      "secret",
+			endpoints: []string{"db:5432"},
+			db:        "orders",
+			args:      []string{"sslmode=disable", ""},
+			want:      []endpoint{{"db", 5432}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := formatDSN(tt.user, tt.pass, tt.endpoints, tt.db, "xpg-test", tt.args...)
+			if strings.HasSuffix(dsn, "&") || strings.Contains(dsn, "&&") || strings.HasSuffix(dsn, "?") {
+				t.Errorf("dsn %q has an empty query argument", dsn)
+			}
+
+			cfg, err := pgx.ParseConfig(dsn)
+			if err != nil {
+				t.Fatalf("ParseConfig(%q): %v", dsn, err)
+			}
+
+			if cfg.User != tt.user || cfg.Password != tt.pass || cfg.Database != tt.db {
+				t.Errorf("user, password, db = %q, %q, %q, want %q, %q, %q",
+					cfg.User, cfg.Password, cfg.Database, tt.user, tt.pass, tt.db)
+			}
+			if got := cfg.RuntimeParams["application_name"]; got != "xpg-test" {
+				t.Errorf("application_name = %q, want xpg-test", got)
+			}
+			for k, v := range tt.wantParam {
+				if got := cfg.RuntimeParams[k]; got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+			if got := cfg.TLSConfig != nil; got != tt.wantTLS {
+				t.Errorf("TLS = %v, want %v", got, tt.wantTLS)
+			}
+
+			got := []endpoint{{cfg.Host, cfg.Port}}
+			for _, fb := range cfg.Fallbacks {
+				if fb.Host != got[len(got)-1].host || fb.Port != got[len(got)-1].port {
+					got = append(got, endpoint{fb.Host, fb.Port})
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("endpoints = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("endpoints = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
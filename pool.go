@@ -2,11 +2,16 @@ package postgres
 
 import (
 	"context"
+	"crypto/tls"
 	"embed"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/squirrel"
@@ -14,12 +19,15 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/jackc/pgx/v5/tracelog"
+	"github.com/mkbeh/xpg/internal/pkg/pgxmetrics"
 	poolcollector "github.com/mkbeh/xpg/internal/pkg/pgxpoolcollector/v5"
 	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
 	"github.com/mkbeh/xpg/internal/pkg/pgxtracer"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -29,11 +37,53 @@ type Pool struct {
 	id            string
 	cfg           *Config
 	logger        *slog.Logger
+	logLevel      *LogLevelVar
 	traceProvider trace.TracerProvider
 	qBuilder      squirrel.StatementBuilderType
 	migrations    []embed.FS
 	namespace     string
+	registerer    prometheus.Registerer
 	labels        prometheus.Labels
+	endpoint      string
+	clustered     bool
+	dsn           string
+	tlsConfig     *tls.Config
+	sizing        PoolSizing
+	// budgetConns is the number of connections reserved from sizing.Budget.
+	budgetConns int32
+
+	lazy     bool
+	retry    *Backoff
+	ready    chan struct{}
+	readyErr error
+
+	passwordProvider PasswordProvider
+	passwordRefresh  time.Duration
+	password         *passwordCache
+
+	failovers    prometheus.Counter
+	txRetries    *prometheus.CounterVec
+	lastFailover atomic.Int64
+
+	collectors []prometheus.Collector
+	closing    atomic.Bool
+	// legacyPoolMetrics also exports the pool stats under their names from before they became counters.
+	legacyPoolMetrics bool
+	queryMetricsOpts  []pgxmetrics.Option
+	queryMetrics      *pgxmetrics.Tracer
+
+	allowReaderWrites  bool
+	logComponent       string
+	logAttrs           []slog.Attr
+	logSampling        float64
+	redaction          *RedactionPolicy
+	slowQueryThreshold time.Duration
+	explainRate        float64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// wgMu orders goBackground against Close, so no goroutine is added to wg once Close waits for it.
+	wgMu sync.Mutex
 }
 
 type options struct {
@@ -46,66 +96,283 @@ type options struct {
 	descriptionCacheCapacity int
 	defaultQueryExecMode     pgx.QueryExecMode
 	logger                   *slog.Logger
+	logLevel                 *LogLevelVar
+	logSampling              float64
 	traceProvider            trace.TracerProvider
 	tracers                  []pgxtracer.QueryTracer
+	traceAttrs               []attribute.KeyValue
+	tlsConfig                *tls.Config
+	rootCertPEM              []byte
+	serverName               string
+	beforeConnect            func(context.Context, *pgx.ConnConfig) error
+	connectTracer            pgx.ConnectTracer
+	prepareConn              func(context.Context, *pgx.Conn) (bool, error)
+	redaction                *RedactionPolicy
 }
 
 func NewWriter(opts ...Option) (*Pool, error) {
-	return newPool(true, opts)
+	return NewWriterContext(context.Background(), opts...)
 }
 
 func NewReader(opts ...Option) (*Pool, error) {
-	return newPool(false, opts)
+	return NewReaderContext(context.Background(), opts...)
 }
 
-func newPool(writer bool, opts []Option) (*Pool, error) {
+// NewWriterContext is like NewWriter but gives up connecting and migrating once ctx is done.
+// With WithLazyConnect ctx only bounds the construction, the background start lives as long as the pool.
+func NewWriterContext(ctx context.Context, opts ...Option) (*Pool, error) {
+	return newPool(ctx, true, opts)
+}
+
+// NewReaderContext is like NewReader but gives up connecting once ctx is done.
+func NewReaderContext(ctx context.Context, opts ...Option) (*Pool, error) {
+	return newPool(ctx, false, opts)
+}
+
+func newPool(ctx context.Context, writer bool, opts []Option) (_ *Pool, err error) {
 	p := &Pool{
-		cfg:      &Config{},
-		logger:   slog.Default(),
-		qBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		cfg:             &Config{},
+		logger:          slog.Default(),
+		logLevel:        NewLogLevelVar(tracelog.LogLevelError),
+		qBuilder:        squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		passwordRefresh: time.Minute,
+		registerer:      prometheus.DefaultRegisterer,
 	}
 
 	for _, opt := range opts {
 		opt.apply(p)
 	}
 
+	// the config may be shared between several pools, so every pool works on its own copy.
+	cfg := *p.cfg
+	p.cfg = &cfg
+
 	p.cfg.writer = writer
+	p.cfg.endpoint = p.endpoint
 	p.cfg.appName = p.getID()
 
+	if p.dsn != "" && p.clustered {
+		return nil, errClusterDSN
+	}
+	if p.dsn == "" {
+		if err := p.validateConfig(); err != nil {
+			return nil, err
+		}
+	}
+
 	if p.traceProvider == nil {
 		p.traceProvider = otel.GetTracerProvider()
 	}
 
-	if writer {
+	switch {
+	case p.logComponent != "":
+		p.logger = p.logger.With(pgxslog.Component(p.logComponent))
+	case writer:
 		p.logger = p.logger.With(pgxslog.Component("postgres_master"))
-	} else {
+	default:
 		p.logger = p.logger.With(pgxslog.Component("postgres_replica"))
 	}
+	if len(p.logAttrs) > 0 {
+		p.logger = slog.New(p.logger.Handler().WithAttrs(p.logAttrs))
+	}
+
+	connOpts := parseConfig(p.cfg, p.sizing)
+	if p.dsn != "" {
+		connOpts.dsn = p.dsn
+		if err := p.useDSNSettings(); err != nil {
+			return nil, err
+		}
+	}
 
-	connOpts := parseConfig(p.cfg)
+	if err := p.reserveConns(connOpts); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			p.releaseConns()
+		}
+	}()
 	connOpts.logger = p.logger
+	connOpts.logLevel = p.logLevel
+	connOpts.logSampling = p.logSampling
 	connOpts.traceProvider = p.traceProvider
+	connOpts.tlsConfig = p.tlsConfig
+	connOpts.redaction = p.redaction
+
+	p.exposeMetrics(writer)
 
-	conn, err := connect(connOpts)
+	if p.passwordProvider != nil {
+		p.password = p.newPasswordCache()
+		connOpts.beforeConnect = p.password.beforeConnect
+		connOpts.connectTracer = p.password
+	}
+
+	if p.registerer != nil {
+		p.queryMetrics = pgxmetrics.New(p.namespace, "postgres", p.labels, append([]pgxmetrics.Option{
+			pgxmetrics.WithErrorClassifier(func(err error) string {
+				return ConvertError(err).Code().String()
+			}),
+		}, p.queryMetricsOpts...)...)
+		connOpts.tracers = append(connOpts.tracers, p.queryMetrics)
+	}
+
+	p.txRetries = p.newTxRetryCounter()
+
+	bgCtx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	if writer {
+		p.failovers = p.newFailoverCounter()
+		connOpts.tracers = append(connOpts.tracers, &failoverTracer{pool: p, ctx: bgCtx})
+	} else {
+		connOpts.traceAttrs = append(connOpts.traceAttrs, attribute.String("db.postgresql.replica", p.cfg.getEndpoint()))
+	}
+
+	if p.slowQueryThreshold > 0 {
+		connOpts.tracers = append(connOpts.tracers, &slowQueryTracer{
+			pool:        p,
+			ctx:         bgCtx,
+			threshold:   p.slowQueryThreshold,
+			explainRate: p.explainRate,
+		})
+	}
+
+	connOpts.prepareConn = p.prepareConn
+
+	// pgxpool keeps using the context to open MinConns in the background,
+	// so the caller canceling it after construction must not affect the pool.
+	conn, err := connect(context.WithoutCancel(ctx), connOpts)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
 	p.Pool = conn
-	p.exposeMetrics(writer)
+	p.ready = make(chan struct{})
 
-	collector := poolcollector.NewStatsCollector(p.namespace, "postgres", p.labels, p.Pool)
-	prometheus.MustRegister(collector)
-
-	if p.cfg.writer && p.cfg.MigrateEnabled {
-		for _, fs := range p.migrations {
-			if err := applyMigrations(fs, p.cfg.getMigrateDSN(), p.logger); err != nil {
-				return nil, err
+	if p.lazy {
+		if err := p.registerMetrics(); err != nil {
+			cancel()
+			p.Pool.Close()
+			return nil, err
+		}
+		p.runBackgroundJobs(bgCtx)
+		p.wg.Go(func() {
+			err := p.start(bgCtx, connOpts)
+			if err != nil {
+				p.Logger().ErrorContext(bgCtx, "failed to start pool", pgxslog.Error(err))
 			}
+			p.markReady(err)
+		})
+		return p, nil
+	}
+
+	if err := p.start(ctx, connOpts); err != nil {
+		cancel()
+		p.Pool.Close()
+		return nil, err
+	}
+	p.markReady(nil)
+
+	if err := p.registerMetrics(); err != nil {
+		cancel()
+		p.Pool.Close()
+		return nil, err
+	}
+	p.runBackgroundJobs(bgCtx)
+
+	return p, nil
+}
+
+func (p *Pool) registerMetrics() error {
+	var collectorOpts []poolcollector.Option
+	if p.legacyPoolMetrics {
+		collectorOpts = append(collectorOpts, poolcollector.WithLegacyNames())
+	}
+
+	err := p.registerCollector(poolcollector.NewStatsCollector(p.namespace, "postgres", p.labels, p.Pool, collectorOpts...))
+	if err == nil && p.queryMetrics != nil {
+		err = p.registerCollector(p.queryMetrics)
+	}
+	if err == nil && p.password != nil {
+		err = p.registerCollector(p.password.failures)
+	}
+	if err == nil && p.failovers != nil {
+		err = p.registerCollector(p.failovers)
+	}
+	if err == nil {
+		err = p.registerCollector(p.txRetries)
+	}
+	if err != nil {
+		p.unregisterCollectors()
+	}
+	return err
+}
+
+func (p *Pool) runBackgroundJobs(ctx context.Context) {
+	if interval := p.cfg.FailoverCheckInterval; p.cfg.writer && interval > 0 {
+		p.wg.Go(func() { p.checkRecoveryLoop(ctx, interval) })
+	}
+}
+
+func (p *Pool) validateConfig() error {
+	checks := checkReader
+	if p.cfg.writer {
+		checks = checkWriter
+	}
+	if p.passwordProvider == nil {
+		checks |= checkPassword
+	}
+
+	if err := p.cfg.validate(checks); err != nil {
+		return errors.Join(errors.New("invalid config"), err)
+	}
+	return nil
+}
+
+// useDSNSettings fills the Config fields used in labels and logs from the connection string given by WithDSN.
+func (p *Pool) useDSNSettings() error {
+	connConfig, err := pgx.ParseConfig(p.dsn)
+	if err != nil {
+		return err
+	}
+	p.cfg.DB = connConfig.Database
+	p.cfg.endpoint = net.JoinHostPort(connConfig.Host, strconv.Itoa(int(connConfig.Port)))
+	return nil
+}
+
+func (p *Pool) migrate(ctx context.Context, opts *options) error {
+	dsn := p.cfg.getMigrateDSN()
+	if p.dsn != "" {
+		dsn = p.dsn
+	}
+
+	dsn, migrateCfg, err := splitMigrateArgs(dsn)
+	if err != nil {
+		return err
+	}
+
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return err
+	}
+
+	if err := configureTLS(&connConfig.Config, opts); err != nil {
+		return err
+	}
+
+	var openOpts []stdlib.OptionOpenDB
+	if opts.beforeConnect != nil {
+		openOpts = append(openOpts, stdlib.OptionBeforeConnect(opts.beforeConnect))
+	}
+
+	for _, fs := range p.migrations {
+		if err := applyMigrations(ctx, fs, connConfig, migrateCfg, p.logger, openOpts...); err != nil {
+			return err
 		}
 	}
 
-	return p, err
+	return nil
 }
 
 func (p *Pool) QueryBuilder() squirrel.StatementBuilderType {
@@ -117,36 +384,144 @@ func (p *Pool) Logger() *slog.Logger {
 }
 
 func (p *Pool) Close() error {
+	p.wgMu.Lock()
+	p.closing.Store(true)
+	p.wgMu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+
+	p.unregisterCollectors()
 	p.Pool.Close()
+	p.releaseConns()
+	return nil
+}
+
+// reserveConns takes the maximum number of connections of the pool from the connection budget, if any,
+// and lowers the maximum to what is left in it.
+func (p *Pool) reserveConns(o *options) error {
+	if p.sizing.Budget == nil {
+		return nil
+	}
+
+	n := p.sizing.Budget.reserve(p.cfg.writer, o.maxConns, p.sizing.readers)
+	if n == 0 {
+		return errConnBudgetExhausted
+	}
+	p.budgetConns = n
+	o.maxConns = n
+	o.minConns = min(o.minConns, n)
 	return nil
 }
 
+func (p *Pool) releaseConns() {
+	if p.budgetConns > 0 {
+		p.sizing.Budget.release(p.cfg.writer, p.budgetConns)
+		p.budgetConns = 0
+	}
+}
+
+// errConnBudgetExhausted fails creating a pool once the other pools hold the whole connection budget.
+var errConnBudgetExhausted = errors.New("connection budget exhausted")
+
+// goBackground runs fn in a goroutine Close waits for, unless the pool is closing.
+func (p *Pool) goBackground(fn func()) bool {
+	p.wgMu.Lock()
+	defer p.wgMu.Unlock()
+
+	if p.closing.Load() {
+		return false
+	}
+	p.wg.Go(fn)
+	return true
+}
+
+// errPoolShutdown fails acquisitions of a pool that is shutting down.
+var errPoolShutdown = errors.New("pool is shutting down")
+
+// shutdownPollInterval is how often Shutdown checks for connections still in use.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown gracefully closes the pool. New queries fail with ErrNoConnection right away,
+// queries in flight are given until ctx is done to release their connections.
+// The pool is closed in any case, the context error is returned if connections were still in use.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.closing.Store(true)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	var err error
+	for p.Pool.Stat().AcquiredConns() > 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			p.Logger().WarnContext(ctx, "closing pool with connections in use",
+				slog.Int("acquired_conns", int(p.Pool.Stat().AcquiredConns())))
+		case <-ticker.C:
+		}
+	}
+
+	return errors.Join(err, p.Close())
+}
+
+// prepareConn rejects acquisitions once Shutdown has been called.
+// The connection is kept, it is closed along with the pool.
+func (p *Pool) prepareConn(context.Context, *pgx.Conn) (bool, error) {
+	if p.closing.Load() {
+		return true, NewPgError(ErrNoConnection, errPoolShutdown)
+	}
+	return true, nil
+}
+
 func (p *Pool) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
 	if tx := extractTx(ctx); tx != nil {
 		return tx.SendBatch(ctx, b)
 	}
-	return p.Pool.SendBatch(ctx, b)
+
+	results := p.Pool.SendBatch(ctx, b)
+	if slices.ContainsFunc(b.QueuedQueries, func(q *pgx.QueuedQuery) bool { return p.tracksWrite(ctx, q.SQL) }) {
+		return &lsnBatchResults{BatchResults: results, pool: p, ctx: ctx}
+	}
+	return results
 }
 
 func (p *Pool) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
 	if tx := extractTx(ctx); tx != nil {
 		return tx.Exec(ctx, sql, arguments...)
 	}
-	return p.Pool.Exec(ctx, sql, arguments...)
+
+	tag, err := p.Pool.Exec(ctx, sql, arguments...)
+	if err == nil && p.tracksWrite(ctx, sql) {
+		p.captureLSN(ctx)
+	}
+	return tag, err
 }
 
 func (p *Pool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
 	if tx := extractTx(ctx); tx != nil {
 		return tx.Query(ctx, sql, args...)
 	}
-	return p.Pool.Query(ctx, sql, args...)
+
+	rows, err := p.Pool.Query(ctx, sql, args...)
+	if err == nil && p.tracksWrite(ctx, sql) {
+		return &lsnRows{Rows: rows, pool: p, ctx: ctx}, nil
+	}
+	return rows, err
 }
 
 func (p *Pool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
 	if tx := extractTx(ctx); tx != nil {
 		return tx.QueryRow(ctx, sql, args...)
 	}
-	return p.Pool.QueryRow(ctx, sql, args...)
+
+	row := p.Pool.QueryRow(ctx, sql, args...)
+	if p.tracksWrite(ctx, sql) {
+		return &lsnRow{Row: row, pool: p, ctx: ctx}
+	}
+	return row
 }
 
 // RunInTxx alias for RunInTx.
@@ -154,13 +529,70 @@ func (p *Pool) RunInTxx(ctx context.Context, fn func(ctx context.Context) error)
 	return p.RunInTx(ctx, fn, pgx.TxOptions{})
 }
 
-func (p *Pool) RunInTx(ctx context.Context, fn func(ctx context.Context) error, txOptions pgx.TxOptions) (err error) {
-	tx, err := p.Pool.BeginTx(ctx, txOptions)
+// RunInTx runs fn in a transaction, committed if fn returns nil and rolled back otherwise.
+// Called within another transaction, it creates a savepoint unless WithPropagation says otherwise.
+func (p *Pool) RunInTx(ctx context.Context, fn func(ctx context.Context) error, txOptions pgx.TxOptions, opts ...TxOption) (err error) {
+	o := newTxOptions(opts)
+
+	parent := extractCtxTx(ctx)
+	switch {
+	case parent.tx == nil, o.propagation == PropagationRequiresNew:
+		parent = nil
+	case o.propagation == PropagationJoin:
+		return fn(ctx)
+	}
+
+	if parent == nil && !p.cfg.writer && !p.allowReaderWrites && txOptions.AccessMode != pgx.ReadOnly {
+		return NewPgError(ErrReadOnlyTransaction, errReaderWrite)
+	}
+
+	if o.retry != nil && parent == nil {
+		return p.retryTx(ctx, *o.retry, func() error {
+			return p.runInTx(ctx, fn, txOptions, nil, o.settings)
+		})
+	}
+	return p.runInTx(ctx, fn, txOptions, parent, o.settings)
+}
+
+// RunInReadTx runs fn in a REPEATABLE READ READ ONLY transaction, so all its queries see the same snapshot.
+// With WithDeferrable the transaction is SERIALIZABLE READ ONLY DEFERRABLE instead.
+func (p *Pool) RunInReadTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	txOptions := pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	}
+	if newTxOptions(opts).deferrable {
+		txOptions.IsoLevel = pgx.Serializable
+		txOptions.DeferrableMode = pgx.Deferrable
+	}
+	return p.RunInTx(ctx, fn, txOptions, opts...)
+}
+
+// errClusterDSN rejects WithDSN in a Cluster, which would connect every replica pool to the same server.
+var errClusterDSN = errors.New("cluster does not support WithDSN, use Config.ClusterHost and Config.ReplicaHosts")
+
+// errReaderWrite rejects read-write transactions on reader pools.
+var errReaderWrite = errors.New("read-write transaction on a reader pool, use pgx.ReadOnly or WithAllowReaderWrites")
+
+// RunInTxWithRetry is RunInTx retrying serialization failures and deadlocks up to 3 times, see WithRetry.
+func (p *Pool) RunInTxWithRetry(ctx context.Context, fn func(ctx context.Context) error, txOptions pgx.TxOptions, opts ...TxOption) error {
+	return p.RunInTx(ctx, fn, txOptions, append([]TxOption{WithRetry(defaultTxRetry)}, opts...)...)
+}
+
+func (p *Pool) runInTx(ctx context.Context, fn func(ctx context.Context) error, txOptions pgx.TxOptions, parent *ctxTx, settings []txSetting) (err error) {
+	var tx pgx.Tx
+	if parent != nil {
+		tx, err = parent.tx.Begin(ctx)
+	} else {
+		tx, err = p.Pool.BeginTx(ctx, txOptions)
+	}
 	if err != nil {
 		p.Logger().ErrorContext(ctx, "failed to begin transaction", pgxslog.Error(err))
 		return NewPgError(ErrBeginTransaction, err)
 	}
 
+	t := &ctxTx{tx: tx, parent: parent}
+
 	defer func() {
 		if r := recover(); r != nil {
 			p.Logger().ErrorContext(ctx, "panic recovered", slog.Any("error", r))
@@ -172,9 +604,22 @@ func (p *Pool) RunInTx(ctx context.Context, fn func(ctx context.Context) error,
 				p.Logger().ErrorContext(ctx, "failed to rollback transaction", pgxslog.Error(rErr))
 			}
 		}
+
+		if err != nil {
+			p.runRollbackHooks(ctx, t, err)
+		}
 	}()
 
-	if err = fn(injectTx(ctx, tx)); err != nil {
+	for _, setting := range settings {
+		if _, err = tx.Exec(ctx, "SELECT set_config($1, $2, true)", setting.name, setting.value); err != nil {
+			p.Logger().ErrorContext(ctx, "failed to apply transaction setting",
+				slog.String("setting", setting.name),
+				pgxslog.Error(err))
+			return NewPgError(ErrBeginTransaction, err)
+		}
+	}
+
+	if err = fn(injectTx(ctx, t)); err != nil {
 		return err
 	}
 
@@ -183,6 +628,14 @@ func (p *Pool) RunInTx(ctx context.Context, fn func(ctx context.Context) error,
 		return NewPgError(ErrCommitTransaction, err)
 	}
 
+	if parent != nil {
+		t.releaseTo(parent)
+		return nil
+	}
+
+	p.captureLSN(ctx)
+	p.runCommitHooks(ctx, t)
+
 	return nil
 }
 
@@ -212,6 +665,7 @@ func (p *Pool) exposeMetrics(writer bool) {
 
 	p.labels["client_id"] = p.getID()
 	p.labels["db"] = p.cfg.DB
+	p.labels["endpoint"] = p.cfg.getEndpoint()
 	p.labels["shard_id"] = strconv.Itoa(p.cfg.ShardID)
 
 	if writer {
@@ -221,19 +675,56 @@ func (p *Pool) exposeMetrics(writer bool) {
 	}
 }
 
-func connect(opts *options) (*pgxpool.Pool, error) {
+// registerCollector registers c with the registerer of the pool and remembers it, so Close can unregister it.
+func (p *Pool) registerCollector(c prometheus.Collector) error {
+	if p.registerer == nil {
+		return nil
+	}
+	if err := p.registerer.Register(c); err != nil {
+		return errors.Join(errors.New("failed to register metrics"), err)
+	}
+	p.collectors = append(p.collectors, c)
+	return nil
+}
+
+func (p *Pool) unregisterCollectors() {
+	for _, c := range p.collectors {
+		p.registerer.Unregister(c)
+	}
+	p.collectors = nil
+}
+
+func connect(ctx context.Context, opts *options) (*pgxpool.Pool, error) {
 	poolCfg, err := pgxpool.ParseConfig(opts.dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	opts.tracers = append(opts.tracers,
-		&tracelog.TraceLog{Logger: pgxslog.NewLogger(opts.logger), LogLevel: tracelog.LogLevelError},
-		otelpgx.NewTracer(
+	var (
+		loggerOpts = []pgxslog.LoggerOption{pgxslog.WithDebugSampling(opts.logSampling)}
+		otelOpts   = []otelpgx.Option{
 			otelpgx.WithTrimSQLInSpanName(),
 			otelpgx.WithTracerProvider(opts.traceProvider),
-		),
+			otelpgx.WithTracerAttributes(opts.traceAttrs...),
+		}
 	)
+	if opts.redaction != nil {
+		loggerOpts = append(loggerOpts, pgxslog.WithRedactor(opts.redaction.redact))
+		otelOpts = append(otelOpts, otelpgx.WithDisableSQLStatementInAttributes())
+	}
+
+	opts.tracers = append(opts.tracers,
+		newLeveledTraceLog(opts.logLevel, pgxslog.NewLogger(opts.logger, loggerOpts...)),
+		otelpgx.NewTracer(otelOpts...),
+	)
+	if opts.redaction != nil {
+		// runs after otelpgx, so the span of the query is already started.
+		opts.tracers = append(opts.tracers, &redactingSpanTracer{policy: opts.redaction})
+	}
+
+	if err := configureTLS(&poolCfg.ConnConfig.Config, opts); err != nil {
+		return nil, err
+	}
 
 	poolCfg.MinConns = opts.minConns
 	poolCfg.MaxConns = opts.maxConns
@@ -244,17 +735,11 @@ func connect(opts *options) (*pgxpool.Pool, error) {
 	poolCfg.ConnConfig.DescriptionCacheCapacity = opts.descriptionCacheCapacity
 	poolCfg.ConnConfig.DefaultQueryExecMode = opts.defaultQueryExecMode
 	poolCfg.ConnConfig.Tracer = pgxtracer.New(opts.tracers...)
-
-	ctx := context.Background()
-
-	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := pool.Ping(ctx); err != nil {
-		return nil, err
+	if opts.connectTracer != nil {
+		poolCfg.ConnConfig.Tracer = pgxtracer.WithConnectTracer(poolCfg.ConnConfig.Tracer, opts.connectTracer)
 	}
+	poolCfg.BeforeConnect = opts.beforeConnect
+	poolCfg.PrepareConn = opts.prepareConn
 
-	return pool, nil
+	return pgxpool.NewWithConfig(ctx, poolCfg)
 }
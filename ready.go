@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
+)
+
+// Backoff configures retries of the initial connection.
+type Backoff struct {
+	// Initial delay before the second attempt, 100ms if not set.
+	Initial time.Duration
+	// Max caps the delay between attempts, 10s if not set.
+	Max time.Duration
+	// Multiplier grows the delay after every attempt, 2 if not set.
+	Multiplier float64
+	// Jitter randomizes every delay by up to the given fraction of it, e.g. 0.2 for ±20%.
+	Jitter float64
+	// MaxAttempts limits the number of attempts, 0 means retrying until the context is done.
+	// A context that is never done, e.g. that of NewWriter and NewReader, is given defaultConnectAttempts instead.
+	MaxAttempts int
+}
+
+// defaultConnectAttempts limits retrying the initial connection when nothing else would stop it,
+// so a synchronous constructor does not block forever while the database is down.
+const defaultConnectAttempts = 10
+
+func (b Backoff) delay(attempt int) time.Duration {
+	initial, maxDelay, multiplier := b.Initial, b.Max, b.Multiplier
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Second
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d := min(float64(initial)*math.Pow(multiplier, float64(attempt)), float64(maxDelay))
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// retry calls fn until it succeeds, the attempts are exhausted or ctx is done.
+func (b Backoff) retry(ctx context.Context, fn func(ctx context.Context) error, onError func(attempt int, err error)) error {
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if b.MaxAttempts > 0 && attempt+1 >= b.MaxAttempts {
+			return err
+		}
+		onError(attempt+1, err)
+
+		timer := time.NewTimer(b.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// start waits for the database to become reachable and applies migrations.
+func (p *Pool) start(ctx context.Context, opts *options) error {
+	ping := p.Pool.Ping
+	if p.retry != nil {
+		backoff := *p.retry
+		if backoff.MaxAttempts <= 0 && ctx.Done() == nil {
+			backoff.MaxAttempts = defaultConnectAttempts
+		}
+		ping = func(ctx context.Context) error {
+			return backoff.retry(ctx, p.Pool.Ping, func(attempt int, err error) {
+				p.Logger().WarnContext(ctx, "failed to connect, retrying",
+					slog.Int("attempt", attempt),
+					pgxslog.Error(err))
+			})
+		}
+	}
+
+	if err := ping(ctx); err != nil {
+		return err
+	}
+
+	if p.cfg.writer && p.cfg.MigrateEnabled {
+		return p.migrate(ctx, opts)
+	}
+
+	return nil
+}
+
+func (p *Pool) markReady(err error) {
+	p.readyErr = err
+	close(p.ready)
+}
+
+// Ready waits until the pool has connected and applied migrations. It returns the error
+// that stopped the start of a lazily connecting pool, or the context error if ctx is done first.
+func (p *Pool) Ready(ctx context.Context) error {
+	select {
+	case <-p.ready:
+		return p.readyErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsReady reports whether the pool has started successfully.
+func (p *Pool) IsReady() bool {
+	select {
+	case <-p.ready:
+		return p.readyErr == nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,379 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"iter"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RedactionMode int
+
+const (
+	// RedactNone keeps the arguments.
+	RedactNone RedactionMode = iota
+	// RedactDrop removes all arguments.
+	RedactDrop
+	// RedactHash replaces the selected arguments with a hash of their value,
+	// so equal values can still be correlated.
+	RedactHash
+	// RedactMask replaces the selected arguments with "***".
+	RedactMask
+)
+
+// maskedArg replaces masked arguments.
+const maskedArg = "***"
+
+// RedactionPolicy decides what query arguments and SQL text end up in logs and spans.
+type RedactionPolicy struct {
+	Mode RedactionMode
+	// Positions selects the arguments hashed or masked by their 1-based parameter number, e.g. 2 for $2.
+	Positions []int
+	// Columns selects the arguments compared to, assigned to or inserted into these columns, e.g. "email".
+	// If neither Positions nor Columns are set, all arguments are hashed or masked, and so are they
+	// if some parameter of the statement can not be mapped to a column.
+	Columns []string
+	// StripLiterals replaces string and numeric literals in the SQL text with "?".
+	StripLiterals bool
+}
+
+// redact applies the policy to a query. The result is only meant for logs and spans.
+func (r *RedactionPolicy) redact(sql string, args []any) (string, []any) {
+	redactedSQL := sql
+	if r.StripLiterals {
+		redactedSQL = stripLiterals(sql)
+	}
+
+	switch r.Mode {
+	case RedactDrop:
+		return redactedSQL, nil
+	case RedactHash, RedactMask:
+	default:
+		return redactedSQL, args
+	}
+
+	selected := r.selected(sql, len(args))
+	redacted := make([]any, len(args))
+	for i, arg := range args {
+		switch {
+		case selected != nil && !selected[i+1]:
+			redacted[i] = arg
+		case r.Mode == RedactHash:
+			redacted[i] = hashArg(arg)
+		default:
+			redacted[i] = maskedArg
+		}
+	}
+	return redactedSQL, redacted
+}
+
+// selected returns the parameter numbers of a query with n arguments to redact, nil meaning all of them.
+// With Columns set, all arguments are redacted unless every one of them can be mapped to a column,
+// so statements the mapping does not understand never leak values.
+func (r *RedactionPolicy) selected(sql string, n int) map[int]bool {
+	if len(r.Positions) == 0 && len(r.Columns) == 0 {
+		return nil
+	}
+
+	selected := make(map[int]bool, len(r.Positions))
+	for _, pos := range r.Positions {
+		selected[pos] = true
+	}
+	if len(r.Columns) > 0 {
+		mapped := make(map[int]bool, n)
+		for column, param := range columnParams(sql) {
+			mapped[param] = true
+			if slices.ContainsFunc(r.Columns, func(c string) bool { return strings.EqualFold(c, column) }) {
+				selected[param] = true
+			}
+		}
+		for param := 1; param <= n; param++ {
+			if !mapped[param] {
+				return nil
+			}
+		}
+	}
+	return selected
+}
+
+func hashArg(arg any) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%v", arg))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+var (
+	// comparisonParam matches "column = $1", "t.column <> $2", "column LIKE $3", "column = ANY($4)" and alike.
+	comparisonParam = regexp.MustCompile(`(?i)"?([a-z_][a-z0-9_]*)"?\s*(?:=|<>|!=|<=|>=|<|>|\s(?:not\s+)?i?like)\s*(?:(?:any|all|some)\s*\(\s*)?\$(\d+)`)
+	// reversedParam matches "$1 = column", "$2 <> t.column" and alike.
+	reversedParam = regexp.MustCompile(`(?i)\$(\d+)\s*(?:=|<>|!=|<=|>=|<|>)\s*(?:"?[a-z_][a-z0-9_]*"?\.)?"?([a-z_][a-z0-9_]*)"?`)
+	// inListParams matches "column IN ($1, $2)" and "column NOT IN (...)".
+	inListParams = regexp.MustCompile(`(?i)"?([a-z_][a-z0-9_]*)"?\s+(?:not\s+)?in\s*\(([^()]*)\)`)
+	// insertParams matches the column list of "INSERT INTO t (a, b) VALUES", the value tuples follow it.
+	insertParams = regexp.MustCompile(`(?is)insert\s+into\s+[^(]+\(([^)]*)\)\s*values\s*`)
+	// rowSetParams matches the column list of "SET (a, b) = ", the value tuple follows it.
+	rowSetParams = regexp.MustCompile(`(?is)\bset\s*\(([^)]*)\)\s*=\s*(?:row\s*)?`)
+	// paramRef matches a parameter, e.g. $1.
+	paramRef = regexp.MustCompile(`\$(\d+)`)
+)
+
+// columnParams maps the columns of sql to the parameter numbers they are compared to or assigned from.
+// Comparisons with expressions, e.g. lower(column) = $1, are not mapped.
+func columnParams(sql string) iter.Seq2[string, int] {
+	return func(yield func(string, int) bool) {
+		// emit maps every parameter of the value expression to the column.
+		emit := func(column, value string) bool {
+			column = strings.Trim(strings.TrimSpace(column), `"`)
+			for _, m := range paramRef.FindAllStringSubmatch(value, -1) {
+				if n, err := strconv.Atoi(m[1]); err == nil && !yield(column, n) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for _, m := range comparisonParam.FindAllStringSubmatchIndex(sql, -1) {
+			if operandStart(sql, m[0]) && !emit(sql[m[2]:m[3]], sql[m[4]-1:m[5]]) {
+				return
+			}
+		}
+
+		for _, m := range reversedParam.FindAllStringSubmatchIndex(sql, -1) {
+			if operandEnd(sql, m[1]) && !emit(sql[m[4]:m[5]], sql[m[2]-1:m[3]]) {
+				return
+			}
+		}
+
+		for _, m := range inListParams.FindAllStringSubmatchIndex(sql, -1) {
+			if operandStart(sql, m[0]) && !emit(sql[m[2]:m[3]], sql[m[4]:m[5]]) {
+				return
+			}
+		}
+
+		for _, m := range insertParams.FindAllStringSubmatchIndex(sql, -1) {
+			columns := strings.Split(sql[m[2]:m[3]], ",")
+			// the tuples are separated by commas: VALUES ($1, $2), ($3, $4).
+			for i := m[1]; ; i++ {
+				values, end, ok := splitTuple(sql, skipSpaces(sql, i))
+				if !ok {
+					break
+				}
+				for j := range min(len(columns), len(values)) {
+					if !emit(columns[j], values[j]) {
+						return
+					}
+				}
+				if i = skipSpaces(sql, end+1); i >= len(sql) || sql[i] != ',' {
+					break
+				}
+			}
+		}
+
+		for _, m := range rowSetParams.FindAllStringSubmatchIndex(sql, -1) {
+			columns := strings.Split(sql[m[2]:m[3]], ",")
+			values, _, ok := splitTuple(sql, m[1])
+			if !ok {
+				continue
+			}
+			for j := range min(len(columns), len(values)) {
+				if !emit(columns[j], values[j]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// operandStart reports whether the column matched at i is a whole operand, not the end of an expression
+// such as "a + column" or "x::column".
+func operandStart(sql string, i int) bool {
+	for i > 0 && isSpace(sql[i-1]) {
+		i--
+	}
+	if i > 0 && sql[i-1] == '.' {
+		// a qualified column, t.column or "t".column.
+		i--
+		for i > 0 && (isIdent(sql[i-1]) || sql[i-1] == '"') {
+			i--
+		}
+		for i > 0 && isSpace(sql[i-1]) {
+			i--
+		}
+	}
+	return i == 0 || sql[i-1] == '(' || sql[i-1] == ',' || isIdent(sql[i-1])
+}
+
+// operandEnd reports whether the column matched up to i is a whole operand,
+// not a function call or the start of an expression such as "column || 'x'".
+func operandEnd(sql string, i int) bool {
+	i = skipSpaces(sql, i)
+	return i == len(sql) || sql[i] == ')' || sql[i] == ',' || sql[i] == ';' || isIdent(sql[i])
+}
+
+// splitTuple splits the parenthesized list starting at sql[i] on its top-level commas
+// and returns the index of the closing parenthesis.
+func splitTuple(sql string, i int) ([]string, int, bool) {
+	if i >= len(sql) || sql[i] != '(' {
+		return nil, 0, false
+	}
+
+	var values []string
+	depth, from := 0, i+1
+	for j := i; j < len(sql); j++ {
+		switch sql[j] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return append(values, sql[from:j]), j, true
+			}
+		case ',':
+			if depth == 1 {
+				values = append(values, sql[from:j])
+				from = j + 1
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+func skipSpaces(sql string, i int) int {
+	for i < len(sql) && isSpace(sql[i]) {
+		i++
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '"' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// stripLiterals replaces string literals, including escape strings E'...' and dollar-quoted $tag$...$tag$ ones,
+// and numeric literals with "?", leaving identifiers, parameters and comments intact.
+func stripLiterals(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+
+	isWord := func(c byte) bool {
+		return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				b.WriteString(sql[i:])
+				return b.String()
+			}
+			b.WriteString(sql[i : i+end])
+			i += end - 1
+
+		case c == '"':
+			end := strings.IndexByte(sql[i+1:], '"')
+			if end < 0 {
+				b.WriteString(sql[i:])
+				return b.String()
+			}
+			b.WriteString(sql[i : i+end+2])
+			i += end + 1
+
+		case c == '\'':
+			b.WriteByte('?')
+			i = stringEnd(sql, i+1, false)
+
+		case (c == 'E' || c == 'e') && i+1 < len(sql) && sql[i+1] == '\'' && (i == 0 || !isWord(sql[i-1])):
+			b.WriteByte('?')
+			i = stringEnd(sql, i+2, true)
+
+		case c == '$' && (i == 0 || !isWord(sql[i-1])) && dollarTag(sql[i:]) != "":
+			tag := dollarTag(sql[i:])
+			end := strings.Index(sql[i+len(tag):], tag)
+			b.WriteByte('?')
+			if end < 0 {
+				return b.String()
+			}
+			i += len(tag) + end + len(tag) - 1
+
+		case c >= '0' && c <= '9' && (i == 0 || !isWord(sql[i-1])):
+			// 1.5, 1e-5, 0x1F and 1_000 alike.
+			j := i
+			for j < len(sql) && (sql[j] == '.' || isWord(sql[j])) {
+				if (sql[j] == 'e' || sql[j] == 'E') && j+1 < len(sql) && (sql[j+1] == '+' || sql[j+1] == '-') {
+					j++
+				}
+				j++
+			}
+			b.WriteByte('?')
+			i = j - 1
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// stringEnd returns the index of the quote closing the string literal starting at i.
+// Quotes inside a string literal are doubled, in an escape string E'...' they can also follow a backslash.
+func stringEnd(sql string, i int, escapes bool) int {
+	for ; i < len(sql); i++ {
+		switch {
+		case escapes && sql[i] == '\\':
+			i++
+		case sql[i] == '\'' && i+1 < len(sql) && sql[i+1] == '\'':
+			i++
+		case sql[i] == '\'':
+			return i
+		}
+	}
+	return i
+}
+
+// dollarTag returns the opening delimiter of the dollar-quoted string sql starts with, e.g. "$$" or "$body$",
+// or "" if it does not start with one.
+func dollarTag(sql string) string {
+	for j := 1; j < len(sql); j++ {
+		c := sql[j]
+		switch {
+		case c == '$':
+			return sql[:j+1]
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80:
+		case c >= '0' && c <= '9' && j > 1:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// redactingSpanTracer sets the redacted SQL text on the spans started by otelpgx,
+// which is configured not to record the statement itself.
+type redactingSpanTracer struct {
+	policy *RedactionPolicy
+}
+
+func (t *redactingSpanTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		sql, _ := t.policy.redact(data.SQL, nil)
+		span.SetAttributes(attribute.String("db.query.text", sql))
+	}
+	return ctx
+}
+
+func (t *redactingSpanTracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
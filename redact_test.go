@@ -0,0 +1,202 @@
+package postgres
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RedactionPolicy
+		sql    string
+		args   []any
+		want   []any
+	}{
+		{
+			name:   "drop",
+			policy: RedactionPolicy{Mode: RedactDrop},
+			sql:    "SELECT * FROM users WHERE email = $1",
+			args:   []any{"a@b.c"},
+			want:   nil,
+		},
+		{
+			name:   "none",
+			policy: RedactionPolicy{Mode: RedactNone, Columns: []string{"email"}},
+			sql:    "SELECT * FROM users WHERE email = $1",
+			args:   []any{"a@b.c"},
+			want:   []any{"a@b.c"},
+		},
+		{
+			name:   "mask all",
+			policy: RedactionPolicy{Mode: RedactMask},
+			sql:    "SELECT * FROM users WHERE id = $1 AND email = $2",
+			args:   []any{1, "a@b.c"},
+			want:   []any{maskedArg, maskedArg},
+		},
+		{
+			name:   "hash",
+			policy: RedactionPolicy{Mode: RedactHash, Positions: []int{2}},
+			sql:    "SELECT * FROM users WHERE id = $1 AND email = $2",
+			args:   []any{1, "a@b.c"},
+			want:   []any{1, hashArg("a@b.c")},
+		},
+		{
+			name:   "positions",
+			policy: RedactionPolicy{Mode: RedactMask, Positions: []int{2}},
+			sql:    "SELECT * FROM users WHERE id = $1 AND email = $2",
+			args:   []any{1, "a@b.c"},
+			want:   []any{1, maskedArg},
+		},
+		{
+			name:   "column comparison",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "SELECT * FROM users u WHERE u.id = $1 AND u.email = $2",
+			args:   []any{1, "a@b.c"},
+			want:   []any{1, maskedArg},
+		},
+		{
+			name:   "column case and quotes",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"Email"}},
+			sql:    `SELECT * FROM users WHERE id = $1 AND "email" LIKE $2`,
+			args:   []any{1, "%@b.c"},
+			want:   []any{1, maskedArg},
+		},
+		{
+			name:   "reversed comparison",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "SELECT * FROM users WHERE $1 = id AND $2 = email",
+			args:   []any{1, "a@b.c"},
+			want:   []any{1, maskedArg},
+		},
+		{
+			name:   "in list",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "SELECT * FROM users WHERE id = $1 AND email IN ($2, $3)",
+			args:   []any{1, "a@b.c", "d@e.f"},
+			want:   []any{1, maskedArg, maskedArg},
+		},
+		{
+			name:   "any",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "SELECT * FROM users WHERE id = $1 AND email = ANY($2)",
+			args:   []any{1, []string{"a@b.c"}},
+			want:   []any{1, maskedArg},
+		},
+		{
+			name:   "insert tuples",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "INSERT INTO users (name, email) VALUES ($1, $2), ($3, lower($4))",
+			args:   []any{"a", "a@b.c", "d", "d@e.f"},
+			want:   []any{"a", maskedArg, "d", maskedArg},
+		},
+		{
+			name:   "update set",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "UPDATE users SET email = $1 WHERE id = $2",
+			args:   []any{"a@b.c", 1},
+			want:   []any{maskedArg, 1},
+		},
+		{
+			name:   "update row constructor",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "UPDATE users SET (name, email) = ($1, $2) WHERE id = $3",
+			args:   []any{"a", "a@b.c", 1},
+			want:   []any{"a", maskedArg, 1},
+		},
+		{
+			name:   "function of a column masks all",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "SELECT * FROM users WHERE id = $1 AND lower(email) = $2",
+			args:   []any{1, "a@b.c"},
+			want:   []any{maskedArg, maskedArg},
+		},
+		{
+			name:   "insert select masks all",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "INSERT INTO users (name, email) SELECT $1, $2",
+			args:   []any{"a", "a@b.c"},
+			want:   []any{maskedArg, maskedArg},
+		},
+		{
+			name:   "unmapped parameter masks all",
+			policy: RedactionPolicy{Mode: RedactMask, Columns: []string{"email"}},
+			sql:    "SELECT * FROM users WHERE email = $1 LIMIT $2",
+			args:   []any{"a@b.c", 10},
+			want:   []any{maskedArg, maskedArg},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := tt.policy.redact(tt.sql, tt.args)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redact(%q) args = %v, want %v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnParams(t *testing.T) {
+	type pair struct {
+		column string
+		param  int
+	}
+
+	tests := []struct {
+		sql  string
+		want []pair
+	}{
+		{"SELECT * FROM t WHERE a = $1 AND t.b <> $2", []pair{{"a", 1}, {"b", 2}}},
+		{`SELECT * FROM t WHERE "a" >= $1 AND b ILIKE $2`, []pair{{"a", 1}, {"b", 2}}},
+		{"SELECT * FROM t WHERE a = ANY($1) AND b <> ALL ($2)", []pair{{"a", 1}, {"b", 2}}},
+		{"SELECT * FROM t WHERE $1 = a AND $2 = t.b", []pair{{"a", 1}, {"b", 2}}},
+		{"SELECT * FROM t WHERE a IN ($1, $2) AND b NOT IN ($3)", []pair{{"a", 1}, {"a", 2}, {"b", 3}}},
+		{"INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4)", []pair{{"a", 1}, {"b", 2}, {"a", 3}, {"b", 4}}},
+		{"UPDATE t SET (a, b) = ($1, $2)", []pair{{"a", 1}, {"b", 2}}},
+		{"SELECT * FROM t WHERE lower(a) = $1", nil},
+		{"SELECT * FROM t WHERE x + a = $1", nil},
+		{"SELECT * FROM t WHERE $1 = lower(a)", nil},
+		{"SELECT * FROM t WHERE $1 = a || b", nil},
+		{"INSERT INTO t (a, b) SELECT $1, $2", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			var got []pair
+			for column, param := range columnParams(tt.sql) {
+				got = append(got, pair{column, param})
+			}
+			slices.SortFunc(got, func(a, b pair) int { return a.param - b.param })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("columnParams(%q) = %v, want %v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripLiterals(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM t WHERE a = 'x' AND b = $1", "SELECT * FROM t WHERE a = ? AND b = $1"},
+		{"SELECT 'it''s'", "SELECT ?"},
+		{`SELECT E'a\'b', e'\\'`, "SELECT ?, ?"},
+		{"SELECT $$it's$$, $fn$ 'a' $x$ $fn$", "SELECT ?, ?"},
+		{"SELECT $$unterminated", "SELECT ?"},
+		{"SELECT 1, 1.5, 1e5, 2.5E-3, 0x1F, 1_000", "SELECT ?, ?, ?, ?, ?, ?"},
+		{"SELECT t1.c2, $3 FROM t1", "SELECT t1.c2, $3 FROM t1"},
+		{`SELECT "a'b" FROM t -- 'comment' 1`, `SELECT "a'b" FROM t -- 'comment' 1`},
+		{"SELECT 'x' -- c\nFROM t WHERE a = 2", "SELECT ? -- c\nFROM t WHERE a = ?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			if got := stripLiterals(tt.sql); got != tt.want {
+				t.Errorf("stripLiterals(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
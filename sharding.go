@@ -0,0 +1,208 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// ShardFunc maps a shard key to one of the given shard IDs. The IDs are sorted in ascending order.
+type ShardFunc func(key string, shardIDs []int) (int, error)
+
+// HashSharding spreads keys evenly over the shards using StringAsHash64.
+func HashSharding() ShardFunc {
+	return func(key string, shardIDs []int) (int, error) {
+		if len(shardIDs) == 0 {
+			return 0, errors.New("no shards")
+		}
+		return shardIDs[StringAsHash64(key)%uint64(len(shardIDs))], nil
+	}
+}
+
+// ShardRange assigns integer keys in [From, To) to a shard.
+type ShardRange struct {
+	From    int64
+	To      int64
+	ShardID int
+}
+
+// RangeSharding maps integer keys to the shard whose range contains them.
+func RangeSharding(ranges ...ShardRange) ShardFunc {
+	return func(key string, _ []int) (int, error) {
+		k, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid range shard key %q: %w", key, err)
+		}
+		for _, r := range ranges {
+			if k >= r.From && k < r.To {
+				return r.ShardID, nil
+			}
+		}
+		return 0, fmt.Errorf("no shard range for key %q", key)
+	}
+}
+
+// LookupSharding maps keys to shards using an explicit table.
+func LookupSharding(table map[string]int) ShardFunc {
+	return func(key string, _ []int) (int, error) {
+		if id, ok := table[key]; ok {
+			return id, nil
+		}
+		return 0, fmt.Errorf("no shard for key %q", key)
+	}
+}
+
+// ShardedCluster holds a pool per shard ID, taken from Config.ShardID of every pool.
+type ShardedCluster struct {
+	shards      map[int]*Pool
+	ids         []int
+	shardFn     ShardFunc
+	parallelism int
+	// duplicates holds the shard IDs passed more than once, which NewShardedCluster rejects.
+	duplicates []int
+}
+
+// A ShardOption lets you configure ShardedCluster using WithShard* funcs.
+type ShardOption interface {
+	applyShard(s *ShardedCluster)
+}
+
+type shardOptionFunc func(s *ShardedCluster)
+
+func (f shardOptionFunc) applyShard(s *ShardedCluster) {
+	f(s)
+}
+
+// WithShards adds pools to the cluster, keyed by their Config.ShardID, which must be unique.
+func WithShards(pools ...*Pool) ShardOption {
+	return shardOptionFunc(func(s *ShardedCluster) {
+		for _, p := range pools {
+			if p == nil {
+				continue
+			}
+			if _, ok := s.shards[p.cfg.ShardID]; ok {
+				s.duplicates = append(s.duplicates, p.cfg.ShardID)
+				continue
+			}
+			s.shards[p.cfg.ShardID] = p
+		}
+	})
+}
+
+func WithShardFunc(fn ShardFunc) ShardOption {
+	return shardOptionFunc(func(s *ShardedCluster) {
+		if fn != nil {
+			s.shardFn = fn
+		}
+	})
+}
+
+// WithShardParallelism limits the number of shards ForEachShard queries at once.
+func WithShardParallelism(n int) ShardOption {
+	return shardOptionFunc(func(s *ShardedCluster) {
+		if n > 0 {
+			s.parallelism = n
+		}
+	})
+}
+
+func NewShardedCluster(opts ...ShardOption) (*ShardedCluster, error) {
+	s := &ShardedCluster{
+		shards:  make(map[int]*Pool),
+		shardFn: HashSharding(),
+	}
+
+	for _, opt := range opts {
+		opt.applyShard(s)
+	}
+
+	if len(s.shards) == 0 {
+		return nil, errors.New("sharded cluster requires at least one shard")
+	}
+	if len(s.duplicates) > 0 {
+		return nil, fmt.Errorf("duplicate shard IDs %v", s.duplicates)
+	}
+
+	for id := range s.shards {
+		s.ids = append(s.ids, id)
+	}
+	slices.Sort(s.ids)
+
+	if s.parallelism == 0 {
+		s.parallelism = len(s.ids)
+	}
+
+	return s, nil
+}
+
+// For returns the pool of the shard the key belongs to, or nil if the key can not be mapped to a known shard.
+func (s *ShardedCluster) For(key string) *Pool {
+	p, _ := s.Lookup(key)
+	return p
+}
+
+// Lookup is like For but reports why the key can not be mapped.
+func (s *ShardedCluster) Lookup(key string) (*Pool, error) {
+	id, err := s.shardFn(key, s.ids)
+	if err != nil {
+		return nil, err
+	}
+	return s.Shard(id)
+}
+
+// Shard returns the pool of the given shard ID.
+func (s *ShardedCluster) Shard(id int) (*Pool, error) {
+	p, ok := s.shards[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown shard %d", id)
+	}
+	return p, nil
+}
+
+// ShardIDs returns the sorted IDs of all shards.
+func (s *ShardedCluster) ShardIDs() []int {
+	return slices.Clone(s.ids)
+}
+
+// ForEachShard calls fn for every shard, running at most WithShardParallelism calls at once.
+// It waits for all calls and returns their errors joined, each prefixed with the shard ID.
+func (s *ShardedCluster) ForEachShard(ctx context.Context, fn func(ctx context.Context, shardID int, p *Pool) error) error {
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, s.parallelism)
+		errs = make([]error, len(s.ids))
+	)
+
+	for i, id := range s.ids {
+		select {
+		case <-ctx.Done():
+			errs[i] = fmt.Errorf("shard %d: %w", id, ctx.Err())
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Go(func() {
+			defer func() { <-sem }()
+			if err := fn(ctx, id, s.shards[id]); err != nil {
+				errs[i] = fmt.Errorf("shard %d: %w", id, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Close closes the pools of all shards and returns their errors joined, each prefixed with the shard ID.
+func (s *ShardedCluster) Close() error {
+	errs := make([]error, 0, len(s.ids))
+	for _, id := range s.ids {
+		if err := s.shards[id].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("shard %d: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+type PoolSizingMode int
+
+const (
+	// SizingExact uses MaxRWConn/MaxROConn as configured, max(4, NumCPU) if they are not set.
+	SizingExact PoolSizingMode = iota
+	// SizingAtLeastNumCPU raises the configured maximum to runtime.NumCPU.
+	SizingAtLeastNumCPU
+	// SizingCPUMultiplier sizes the pool to runtime.NumCPU times Multiplier,
+	// capped by the configured maximum if set.
+	SizingCPUMultiplier
+	// SizingGOMAXPROCS is like SizingCPUMultiplier but uses GOMAXPROCS,
+	// which follows cgroup CPU limits of containers.
+	SizingGOMAXPROCS
+)
+
+// PoolSizing decides the maximum number of connections of a pool.
+type PoolSizing struct {
+	Mode PoolSizingMode
+	// Multiplier applies to SizingCPUMultiplier and SizingGOMAXPROCS, 1 if not set.
+	Multiplier float64
+	// Budget caps the connections of every pool created with it, see NewConnBudget.
+	Budget *ConnBudget
+
+	// readers is the number of reader pools of a Cluster, this one included, still to draw from the budget.
+	readers int
+}
+
+func (s PoolSizing) maxConns(configured int32) int32 {
+	var n int32
+	switch s.Mode {
+	case SizingAtLeastNumCPU:
+		n = max(configured, 4, int32(runtime.NumCPU()))
+
+	case SizingCPUMultiplier:
+		n = s.scale(runtime.NumCPU(), configured)
+
+	case SizingGOMAXPROCS:
+		n = s.scale(runtime.GOMAXPROCS(0), configured)
+
+	default:
+		n = configured
+		if n <= 0 {
+			n = max(4, int32(runtime.NumCPU()))
+		}
+	}
+
+	return max(n, 1)
+}
+
+func (s PoolSizing) scale(cpus int, configured int32) int32 {
+	multiplier := s.Multiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+
+	n := int32(math.Ceil(float64(cpus) * multiplier))
+	if configured > 0 {
+		n = min(n, configured)
+	}
+	return n
+}
+
+// ConnBudget is a number of connections shared by all pools created with it, e.g. the PgBouncer pool size
+// available to the process. Writer pools draw from the writer share of it, reader pools from the rest.
+// Every pool reserves its maximum number of connections when it is created and returns it on Close.
+type ConnBudget struct {
+	mu sync.Mutex
+	// writer and reader hold the connections still available to writer and reader pools.
+	writer int32
+	reader int32
+}
+
+// NewConnBudget creates a budget of total connections, writerShare of them for writer pools, 0.5 if not set.
+func NewConnBudget(total int32, writerShare float64) *ConnBudget {
+	if writerShare <= 0 || writerShare >= 1 {
+		writerShare = 0.5
+	}
+
+	writer := int32(float64(total) * writerShare)
+	return &ConnBudget{writer: writer, reader: total - writer}
+}
+
+// reserve takes up to want connections for a pool, sharing what is left evenly with the given
+// number of pools still to come, this one included. It returns 0 once the budget is exhausted.
+func (b *ConnBudget) reserve(writer bool, want int32, shares int) int32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	available := &b.reader
+	if writer {
+		available = &b.writer
+	}
+
+	n := max(min(want, *available/int32(max(shares, 1))), 0)
+	*available -= n
+	return n
+}
+
+func (b *ConnBudget) release(writer bool, n int32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if writer {
+		b.writer += n
+	} else {
+		b.reader += n
+	}
+}
@@ -0,0 +1,31 @@
+package postgres
+
+import "testing"
+
+func TestConnBudget(t *testing.T) {
+	b := NewConnBudget(10, 0.2)
+
+	if n := b.reserve(true, 8, 1); n != 2 {
+		t.Fatalf("writer reserved %d, want 2", n)
+	}
+	if n := b.reserve(true, 8, 1); n != 0 {
+		t.Fatalf("second writer reserved %d, want 0", n)
+	}
+
+	// three readers of a cluster split the reader share evenly.
+	var got []int32
+	for shares := 3; shares > 0; shares-- {
+		got = append(got, b.reserve(false, 100, shares))
+	}
+	if got[0]+got[1]+got[2] != 8 || got[0] != 2 || got[2] != 3 {
+		t.Fatalf("readers reserved %v, want [2 3 3]", got)
+	}
+	if n := b.reserve(false, 1, 1); n != 0 {
+		t.Fatalf("standalone reader reserved %d from an exhausted budget, want 0", n)
+	}
+
+	b.release(false, got[0])
+	if n := b.reserve(false, 4, 1); n != 2 {
+		t.Fatalf("reader reserved %d after release, want 2", n)
+	}
+}
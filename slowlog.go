@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mkbeh/xpg/internal/pkg/pgxmetrics"
+	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
+)
+
+const (
+	// explainTimeout bounds connecting and running EXPLAIN for a slow query.
+	explainTimeout = 5 * time.Second
+	// maxLoggedArgLen truncates long text and binary arguments in the slow query log.
+	maxLoggedArgLen = 64
+)
+
+// slowQueryTracer logs queries running longer than the threshold
+// and attaches the plan of a sampled subset of them.
+type slowQueryTracer struct {
+	pool        *Pool
+	ctx         context.Context
+	threshold   time.Duration
+	explainRate float64
+	// explaining allows a single EXPLAIN at a time, so a burst of slow queries does not open a connection each.
+	explaining atomic.Bool
+}
+
+type slowQueryKey struct{}
+
+var slowQueryMarkerKey = &slowQueryKey{}
+
+type slowQueryStart struct {
+	sql   string
+	args  []any
+	start time.Time
+}
+
+func (t *slowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, slowQueryMarkerKey, slowQueryStart{sql: data.SQL, args: data.Args, start: time.Now()})
+}
+
+func (t *slowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(slowQueryMarkerKey).(slowQueryStart)
+	if !ok {
+		return
+	}
+
+	duration := time.Since(q.start)
+	if duration < t.threshold {
+		return
+	}
+
+	sql, args := q.sql, q.args
+	if t.pool.redaction != nil {
+		sql, args = t.pool.redaction.redact(sql, args)
+	}
+
+	attrs := []slog.Attr{
+		slog.String("sql", sql),
+		slog.Duration("duration", duration),
+		slog.Int64("rows_affected", data.CommandTag.RowsAffected()),
+		slog.String("role", t.pool.role()),
+	}
+	if args != nil {
+		attrs = append(attrs, slog.Any("args", sanitizeArgs(args)))
+	}
+	if data.Err != nil {
+		attrs = append(attrs, pgxslog.Error(data.Err))
+	}
+
+	if !t.sampled(q.sql) || !t.explaining.CompareAndSwap(false, true) {
+		t.pool.Logger().LogAttrs(ctx, slog.LevelWarn, "slow query", attrs...)
+		return
+	}
+
+	// the plan is captured in the background, so the slow query does not get even slower.
+	started := t.pool.goBackground(func() {
+		defer t.explaining.Store(false)
+
+		plan, err := t.pool.explain(t.ctx, q.sql, q.args)
+		if err != nil {
+			attrs = append(attrs, slog.String("plan_error", err.Error()))
+		} else {
+			attrs = append(attrs, slog.String("plan", plan))
+		}
+		t.pool.Logger().LogAttrs(t.ctx, slog.LevelWarn, "slow query", attrs...)
+	})
+	if !started {
+		t.explaining.Store(false)
+		t.pool.Logger().LogAttrs(ctx, slog.LevelWarn, "slow query", attrs...)
+	}
+}
+
+// sampled reports whether the plan of the statement should be captured.
+// Plans show the argument values in their filters, so they are not captured under a redaction policy.
+func (t *slowQueryTracer) sampled(sql string) bool {
+	if t.explainRate <= 0 || t.pool.redaction != nil {
+		return false
+	}
+	if operation, _ := pgxmetrics.Classify(sql); operation == "other" {
+		return false
+	}
+	return t.explainRate >= 1 || rand.Float64() < t.explainRate
+}
+
+// explain runs EXPLAIN (FORMAT JSON) on a dedicated connection without tracers,
+// so neither the pool capacity is used nor the EXPLAIN itself is logged as a slow query.
+func (p *Pool) explain(ctx context.Context, sql string, args []any) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, explainTimeout)
+	defer cancel()
+
+	poolCfg := p.Pool.Config()
+	connCfg := poolCfg.ConnConfig.Copy()
+	connCfg.Tracer = nil
+	if poolCfg.BeforeConnect != nil {
+		if err := poolCfg.BeforeConnect(ctx, connCfg); err != nil {
+			return "", err
+		}
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, connCfg)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	var plan string
+	if err := conn.QueryRow(ctx, "EXPLAIN (FORMAT JSON) "+sql, args...).Scan(&plan); err != nil {
+		return "", err
+	}
+	return plan, nil
+}
+
+func (p *Pool) role() string {
+	if p.cfg.writer {
+		return "master"
+	}
+	return "replica"
+}
+
+// sanitizeArgs shortens long text and binary arguments, so they do not flood the log.
+func sanitizeArgs(args []any) []any {
+	sanitized := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			if len(v) > maxLoggedArgLen {
+				v = v[:maxLoggedArgLen] + fmt.Sprintf("... (%d bytes)", len(v))
+			}
+			sanitized[i] = v
+		case []byte:
+			sanitized[i] = fmt.Sprintf("<%d bytes>", len(v))
+		default:
+			sanitized[i] = arg
+		}
+	}
+	return sanitized
+}
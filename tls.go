@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	SSLModeDisable    = "disable"
+	SSLModeAllow      = "allow"
+	SSLModePrefer     = "prefer"
+	SSLModeRequire    = "require"
+	SSLModeVerifyCA   = "verify-ca"
+	SSLModeVerifyFull = "verify-full"
+)
+
+func (c *Config) getSSLMode() string {
+	if c.SSLMode != "" {
+		return c.SSLMode
+	}
+	return SSLModeDisable
+}
+
+// rootCertPEM returns SSLRootCert if it holds the certificate itself rather than a path to it.
+func (c *Config) rootCertPEM() []byte {
+	if strings.Contains(c.SSLRootCert, "-----BEGIN") {
+		return []byte(c.SSLRootCert)
+	}
+	return nil
+}
+
+// tlsArgs returns the DSN arguments of the TLS settings understood by libpq-compatible drivers.
+func (c *Config) tlsArgs() string {
+	args := url.Values{}
+	args.Set("sslmode", c.getSSLMode())
+
+	if c.SSLRootCert != "" && c.rootCertPEM() == nil {
+		args.Set("sslrootcert", c.SSLRootCert)
+	}
+	if c.SSLCert != "" {
+		args.Set("sslcert", c.SSLCert)
+	}
+	if c.SSLKey != "" {
+		args.Set("sslkey", c.SSLKey)
+	}
+
+	return args.Encode()
+}
+
+// configureTLS applies the TLS settings that can not be expressed in the DSN
+// to the parsed connection config and all its fallbacks.
+func configureTLS(cfg *pgconn.Config, opts *options) error {
+	if opts.tlsConfig != nil {
+		useTLSConfig(cfg, opts.tlsConfig)
+		return nil
+	}
+
+	if len(opts.rootCertPEM) == 0 && opts.serverName == "" {
+		return nil
+	}
+
+	var roots *x509.CertPool
+	if len(opts.rootCertPEM) > 0 {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(opts.rootCertPEM) {
+			return errors.New("failed to parse root certificate PEM")
+		}
+	}
+
+	tlsConfigs := []*tls.Config{cfg.TLSConfig}
+	for _, fb := range cfg.Fallbacks {
+		tlsConfigs = append(tlsConfigs, fb.TLSConfig)
+	}
+
+	// pgx verifies verify-ca certificates against RootCAs of the very same tls.Config,
+	// so the settings are changed in place.
+	for _, tlsConfig := range tlsConfigs {
+		if tlsConfig == nil {
+			continue
+		}
+		if roots != nil {
+			tlsConfig.RootCAs = roots
+		}
+		if opts.serverName != "" {
+			tlsConfig.ServerName = opts.serverName
+		}
+	}
+
+	return nil
+}
+
+// useTLSConfig makes every connection attempt use the given TLS config,
+// dropping the plain-text fallbacks added by sslmode=allow/prefer.
+func useTLSConfig(cfg *pgconn.Config, tlsConfig *tls.Config) {
+	cfg.TLSConfig = tlsConfigForHost(tlsConfig, cfg.Host)
+
+	seen := map[string]bool{net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port))): true}
+	fallbacks := cfg.Fallbacks[:0]
+	for _, fb := range cfg.Fallbacks {
+		key := net.JoinHostPort(fb.Host, strconv.Itoa(int(fb.Port)))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		fb.TLSConfig = tlsConfigForHost(tlsConfig, fb.Host)
+		fallbacks = append(fallbacks, fb)
+	}
+	cfg.Fallbacks = fallbacks
+}
+
+func tlsConfigForHost(tlsConfig *tls.Config, host string) *tls.Config {
+	c := tlsConfig.Clone()
+	if c.ServerName == "" && !strings.HasPrefix(host, "/") {
+		c.ServerName = host
+	}
+	return c
+}
@@ -2,12 +2,22 @@ package postgres
 
 import (
 	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 )
 
 type ctxTx struct {
 	tx pgx.Tx
+	// parent is the transaction a savepoint belongs to, nil for a top-level transaction.
+	parent *ctxTx
+
+	mu         sync.Mutex
+	onCommit   []func(ctx context.Context)
+	onRollback []func(ctx context.Context, err error)
 }
 
 type txKey struct{}
@@ -17,17 +27,203 @@ var (
 	nullTx      = &ctxTx{}
 )
 
-func injectTx(ctx context.Context, tx pgx.Tx) context.Context {
-	t := &ctxTx{
-		tx: tx,
-	}
+func injectTx(ctx context.Context, t *ctxTx) context.Context {
 	return context.WithValue(ctx, txMarkerKey, t)
 }
 
 func extractTx(ctx context.Context) pgx.Tx {
+	return extractCtxTx(ctx).tx
+}
+
+func extractCtxTx(ctx context.Context) *ctxTx {
 	t, ok := ctx.Value(txMarkerKey).(*ctxTx)
 	if !ok || t == nil {
-		return nullTx.tx
+		return nullTx
+	}
+	return t
+}
+
+// OnCommit registers fn to run after the transaction in ctx commits, in the order of registration.
+// Hooks of a savepoint wait for the commit of the top-level transaction and are dropped if the savepoint
+// is rolled back. Without a transaction in ctx fn runs immediately.
+func OnCommit(ctx context.Context, fn func(ctx context.Context)) {
+	t := extractCtxTx(ctx)
+	if t.tx == nil {
+		fn(ctx)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onCommit = append(t.onCommit, fn)
+}
+
+// OnRollback registers fn to run after the transaction in ctx, or the savepoint, is rolled back,
+// with the error that caused the rollback. Without a transaction in ctx fn is never called.
+func OnRollback(ctx context.Context, fn func(ctx context.Context, err error)) {
+	t := extractCtxTx(ctx)
+	if t.tx == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onRollback = append(t.onRollback, fn)
+}
+
+// releaseTo hands the hooks of a released savepoint over to its parent,
+// as its changes now commit or roll back along with the parent.
+func (t *ctxTx) releaseTo(parent *ctxTx) {
+	t.mu.Lock()
+	onCommit, onRollback := t.onCommit, t.onRollback
+	t.mu.Unlock()
+
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+	parent.onCommit = append(parent.onCommit, onCommit...)
+	parent.onRollback = append(parent.onRollback, onRollback...)
+}
+
+func (p *Pool) runCommitHooks(ctx context.Context, t *ctxTx) {
+	t.mu.Lock()
+	hooks := t.onCommit
+	t.mu.Unlock()
+
+	for _, hook := range hooks {
+		p.runHook(ctx, func() { hook(ctx) })
+	}
+}
+
+func (p *Pool) runRollbackHooks(ctx context.Context, t *ctxTx, err error) {
+	t.mu.Lock()
+	hooks := t.onRollback
+	t.mu.Unlock()
+
+	for _, hook := range hooks {
+		p.runHook(ctx, func() { hook(ctx, err) })
+	}
+}
+
+// runHook recovers and logs panics, so a failing hook neither skips the following ones nor crashes the caller.
+func (p *Pool) runHook(ctx context.Context, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.Logger().ErrorContext(ctx, "panic recovered in transaction hook", slog.Any("error", r))
+		}
+	}()
+	hook()
+}
+
+// TxPropagation decides how RunInTx behaves when the context already carries a transaction.
+type TxPropagation int
+
+const (
+	// PropagationSavepoint runs the nested call in a SAVEPOINT of the outer transaction: an error or panic
+	// rolls back to the savepoint only, success releases it. The pgx.TxOptions of the nested call are ignored.
+	PropagationSavepoint TxPropagation = iota
+	// PropagationJoin runs the nested call in the outer transaction as is, its error aborts the outer
+	// transaction if the outer function returns it.
+	PropagationJoin
+	// PropagationRequiresNew runs the nested call in an independent transaction on another connection,
+	// committed or rolled back regardless of the outer one.
+	PropagationRequiresNew
+)
+
+// A TxOption configures a single RunInTx call.
+type TxOption interface {
+	applyTx(o *txOptions)
+}
+
+type txOptionFunc func(o *txOptions)
+
+func (fn txOptionFunc) applyTx(o *txOptions) {
+	fn(o)
+}
+
+type txOptions struct {
+	propagation TxPropagation
+	retry       *Backoff
+	deferrable  bool
+	settings    []txSetting
+}
+
+type txSetting struct {
+	name  string
+	value string
+}
+
+func newTxOptions(opts []TxOption) *txOptions {
+	o := &txOptions{}
+	for _, opt := range opts {
+		opt.applyTx(o)
+	}
+	return o
+}
+
+// WithPropagation sets the behavior of RunInTx called within a transaction, PropagationSavepoint by default.
+func WithPropagation(propagation TxPropagation) TxOption {
+	return txOptionFunc(func(o *txOptions) {
+		o.propagation = propagation
+	})
+}
+
+// WithRetry re-runs the whole transaction when it fails with a serialization failure (40001) or a deadlock (40P01),
+// waiting between the attempts as the backoff says. A MaxAttempts of 0 means 3 attempts here.
+// Transactions nested in another one are never retried, as the outer transaction is already aborted.
+func WithRetry(backoff Backoff) TxOption {
+	return txOptionFunc(func(o *txOptions) {
+		o.retry = &backoff
+	})
+}
+
+// WithDeferrable makes RunInReadTx run a SERIALIZABLE READ ONLY DEFERRABLE transaction. It may wait for a safe
+// snapshot at the start, but then never fails with a serialization error, which suits long reporting queries.
+// Hot standbys do not support SERIALIZABLE, so Cluster.RunInReadTx runs deferrable transactions on the writer.
+func WithDeferrable() TxOption {
+	return txOptionFunc(func(o *txOptions) {
+		o.deferrable = true
+	})
+}
+
+// WithStatementTimeout sets statement_timeout for the transaction only.
+// d is rounded up to whole milliseconds, 0 disables the timeout and negative values are ignored.
+func WithStatementTimeout(d time.Duration) TxOption {
+	return withTimeout("statement_timeout", d)
+}
+
+// WithLockTimeout sets lock_timeout for the transaction only, rounded like WithStatementTimeout.
+func WithLockTimeout(d time.Duration) TxOption {
+	return withTimeout("lock_timeout", d)
+}
+
+// WithIdleInTransactionTimeout sets idle_in_transaction_session_timeout for the transaction only,
+// rounded like WithStatementTimeout.
+func WithIdleInTransactionTimeout(d time.Duration) TxOption {
+	return withTimeout("idle_in_transaction_session_timeout", d)
+}
+
+// WithSetting sets a configuration parameter for the transaction only, like SET LOCAL, right after it begins.
+// Custom parameters such as app.tenant_id can be read by row-level security policies with current_setting.
+// Settings of a savepoint stay in effect until the end of the outer transaction once it is released,
+// and are not applied with PropagationJoin.
+func WithSetting(name, value string) TxOption {
+	return txOptionFunc(func(o *txOptions) {
+		o.settings = append(o.settings, txSetting{name: name, value: value})
+	})
+}
+
+func withTimeout(name string, d time.Duration) TxOption {
+	if d < 0 {
+		return txOptionFunc(func(*txOptions) {})
+	}
+	return WithSetting(name, formatMillis(d))
+}
+
+// formatMillis rounds d up to whole milliseconds, so a short timeout does not turn into 0, which disables it.
+func formatMillis(d time.Duration) string {
+	ms := d.Milliseconds()
+	if d%time.Millisecond > 0 {
+		ms++
 	}
-	return t.tx
+	return strconv.FormatInt(ms, 10)
 }
@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mkbeh/xpg/internal/pkg/pgxslog"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultTxRetry is used by RunInTxWithRetry.
+var defaultTxRetry = Backoff{
+	Initial:     20 * time.Millisecond,
+	Max:         time.Second,
+	Jitter:      0.2,
+	MaxAttempts: 3,
+}
+
+// retryableTxError returns the code of a serialization failure or deadlock, which succeed if the transaction is run again.
+func retryableTxError(err error) (PgErrorCode, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return 0, false
+	}
+
+	switch pgErr.Code {
+	case pgerrcode.SerializationFailure:
+		return ErrSerializable, true
+	case pgerrcode.DeadlockDetected:
+		return ErrDeadlock, true
+	default:
+		return 0, false
+	}
+}
+
+// retryTx calls run until it succeeds, fails with a non-retryable error, the attempts are exhausted or ctx is done.
+func (p *Pool) retryTx(ctx context.Context, backoff Backoff, run func() error) error {
+	maxAttempts := backoff.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := run()
+		code, retryable := retryableTxError(err)
+		if !retryable || attempt >= maxAttempts {
+			return err
+		}
+
+		p.txRetries.WithLabelValues(code.String()).Inc()
+		trace.SpanFromContext(ctx).AddEvent("transaction retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("code", code.String()),
+			attribute.String("error", err.Error()),
+		))
+		p.Logger().DebugContext(ctx, "retrying transaction",
+			slog.Int("attempt", attempt),
+			pgxslog.Error(err))
+
+		timer := time.NewTimer(backoff.delay(attempt - 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *Pool) newTxRetryCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   p.namespace,
+		Subsystem:   "postgres",
+		Name:        "tx_retries_total",
+		Help:        "Number of transactions run again after a serialization failure or deadlock.",
+		ConstLabels: p.labels,
+	}, []string{"code"})
+}
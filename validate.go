@@ -0,0 +1,161 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Validate checks the configuration of both writer and reader pools
+// and returns every problem found, joined into one error.
+func (c *Config) Validate() error {
+	return c.validate(checkWriter | checkReader | checkPassword)
+}
+
+const (
+	checkWriter = 1 << iota
+	checkReader
+	// checkPassword is left out if the password is supplied by a PasswordProvider.
+	checkPassword
+)
+
+func (c *Config) validate(checks int) error {
+	var errs []error
+
+	require := func(name, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+
+	// reader pools of a Cluster get their endpoint from ReplicaHosts,
+	// a standalone reader always connects to ClusterHost and ClusterReplicaPort.
+	if c.endpoint == "" {
+		writerHost := checks&checkWriter != 0 && len(c.ClusterHosts) == 0
+		if writerHost || checks&checkReader != 0 {
+			require("ClusterHost", c.ClusterHost)
+		}
+		if writerHost {
+			require("ClusterPort", c.ClusterPort)
+		}
+		if checks&checkReader != 0 {
+			require("ClusterReplicaPort", c.ClusterReplicaPort)
+		}
+	}
+	require("User", c.User)
+	require("DB", c.DB)
+	if checks&checkPassword != 0 {
+		require("Password", c.Password)
+	}
+
+	errs = append(errs,
+		validatePort("ClusterPort", c.ClusterPort),
+		validatePort("ClusterReplicaPort", c.ClusterReplicaPort),
+		validatePort("MigratePort", c.MigratePort),
+		validateHosts("ClusterHosts", c.ClusterHosts, c.ClusterPort),
+		validateHosts("ReplicaHosts", c.ReplicaHosts, c.ClusterReplicaPort),
+		validateConns("RW", c.MinRWConn, c.MaxRWConn),
+		validateConns("RO", c.MinROConn, c.MaxROConn),
+		validateOneOf("QueryExecMode", c.QueryExecMode, QueryExecModeCacheStatement, QueryExecModeCacheDescribe,
+			QueryExecModeDescribeExec, QueryExecModeExec, QueryExecModeSimpleProtocol),
+		validateOneOf("ReplicaBalancing", c.ReplicaBalancing, BalancingRoundRobin, BalancingRandom, BalancingLeastConns),
+		validateOneOf("SSLMode", c.SSLMode, SSLModeDisable, SSLModeAllow, SSLModePrefer, SSLModeRequire,
+			SSLModeVerifyCA, SSLModeVerifyFull),
+		validateArgs("MasterArgs", c.MasterArgs),
+		validateArgs("ReplicaArgs", c.ReplicaArgs),
+		validateArgs("MigrateArgs", c.MigrateArgs),
+	)
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"MaxConnLifetime", c.MaxConnLifetime},
+		{"MaxConnIdleTime", c.MaxConnIdleTime},
+		{"ReplicaCheckInterval", c.ReplicaCheckInterval},
+		{"ReplicaCooldown", c.ReplicaCooldown},
+		{"MaxReplicaLag", c.MaxReplicaLag},
+		{"ReadYourWritesTimeout", c.ReadYourWritesTimeout},
+		{"FailoverCheckInterval", c.FailoverCheckInterval},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %s", d.name, d.value))
+		}
+	}
+
+	if c.StatementCacheCapacity < 0 {
+		errs = append(errs, fmt.Errorf("StatementCacheCapacity must not be negative, got %d", c.StatementCacheCapacity))
+	}
+	if c.DescriptionCacheCapacity < 0 {
+		errs = append(errs, fmt.Errorf("DescriptionCacheCapacity must not be negative, got %d", c.DescriptionCacheCapacity))
+	}
+
+	if c.MigrateEnabled && c.MigratePort == "" && c.ClusterPort == "" && len(c.ClusterHosts) == 0 {
+		errs = append(errs, errors.New("MigratePort or ClusterPort is required when MigrateEnabled is set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validatePort(name, port string) error {
+	if port == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s must be a port number between 1 and 65535, got %q", name, port)
+	}
+	return nil
+}
+
+// validateHosts checks host[:port] entries; entries without a port need the default one.
+func validateHosts(name string, hosts []string, defaultPort string) error {
+	var errs []error
+	for i, host := range hosts {
+		h, port, err := net.SplitHostPort(host)
+		if err != nil {
+			h, port = host, defaultPort
+		}
+
+		switch {
+		case h == "":
+			errs = append(errs, fmt.Errorf("%s[%d]: host is empty", name, i))
+		case port == "":
+			errs = append(errs, fmt.Errorf("%s[%d]: %q has no port and no default port is configured", name, i, host))
+		default:
+			errs = append(errs, validatePort(fmt.Sprintf("%s[%d] port", name, i), port))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateConns(kind string, minConns, maxConns int32) error {
+	var errs []error
+	if minConns < 0 {
+		errs = append(errs, fmt.Errorf("Min%sConn must not be negative, got %d", kind, minConns))
+	}
+	if maxConns < 0 {
+		errs = append(errs, fmt.Errorf("Max%sConn must not be negative, got %d", kind, maxConns))
+	}
+	if maxConns > 0 && minConns > maxConns {
+		errs = append(errs, fmt.Errorf("Min%sConn (%d) must not exceed Max%sConn (%d)", kind, minConns, kind, maxConns))
+	}
+	return errors.Join(errs...)
+}
+
+func validateOneOf(name, value string, allowed ...string) error {
+	if value == "" || slices.Contains(allowed, value) {
+		return nil
+	}
+	return fmt.Errorf("unknown %s %q, expected one of %v", name, value, allowed)
+}
+
+func validateArgs(name, args string) error {
+	if _, err := url.ParseQuery(args); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}